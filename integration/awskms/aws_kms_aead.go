@@ -20,18 +20,73 @@ package awskms
 import (
 	"context"
 	"encoding/hex"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 )
 
+// ContextAEAD is implemented by AEAD primitives that can bind an individual
+// call to a caller-supplied context, in addition to the context-free
+// tink.AEAD interface. AWSAEAD implements it: since tink.AEAD.Encrypt and
+// tink.AEAD.Decrypt have no way to accept a context, callers that need to
+// bound or cancel a specific KMS call should type-assert the primitive
+// returned by AWSClient.GetAEAD to ContextAEAD and use EncryptContext or
+// DecryptContext instead.
+type ContextAEAD interface {
+	EncryptContext(ctx context.Context, plaintext, associatedData []byte) ([]byte, error)
+	DecryptContext(ctx context.Context, ciphertext, associatedData []byte) ([]byte, error)
+}
+
+// ClientObserver receives notifications about Encrypt and Decrypt calls
+// made through an AWSAEAD, and about the underlying KMS calls they make, so
+// that callers can export them as metrics or tracing spans without forking
+// this package. See WithObserver and the awskms/otel subpackage.
+//
+// The *Start methods return a context.Context, which AWSAEAD threads
+// through to the rest of the call (including OnKMSCall and the matching
+// *End call): this lets an observer attach request-scoped state, such as a
+// tracing span, via the returned context.
+type ClientObserver interface {
+	// OnEncryptStart is called at the start of an Encrypt/EncryptContext
+	// call.
+	OnEncryptStart(ctx context.Context, keyURI string) context.Context
+	// OnEncryptEnd is called when an Encrypt/EncryptContext call finishes,
+	// whether or not it succeeded.
+	OnEncryptEnd(ctx context.Context, keyURI string, err error, latency time.Duration, ciphertextBytes int)
+	// OnDecryptStart is called at the start of a Decrypt/DecryptContext
+	// call.
+	OnDecryptStart(ctx context.Context, keyURI string) context.Context
+	// OnDecryptEnd is called when a Decrypt/DecryptContext call finishes,
+	// whether or not it succeeded.
+	OnDecryptEnd(ctx context.Context, keyURI string, err error, latency time.Duration, plaintextBytes int)
+	// OnKMSCall is called after each individual call to the KMS API
+	// (currently Encrypt or Decrypt, named by op). encryptionContext is the
+	// EncryptionContext sent with the request, if any; observers that
+	// export it (e.g. as tracing attributes) should treat the values as
+	// sensitive and redact them, since they are derived from the caller's
+	// associated data.
+	OnKMSCall(ctx context.Context, op, keyURI string, encryptionContext map[string]string, err error, latency time.Duration)
+}
+
+// noopObserver is the default ClientObserver used when none is configured.
+type noopObserver struct{}
+
+func (noopObserver) OnEncryptStart(ctx context.Context, _ string) context.Context    { return ctx }
+func (noopObserver) OnEncryptEnd(context.Context, string, error, time.Duration, int) {}
+func (noopObserver) OnDecryptStart(ctx context.Context, _ string) context.Context    { return ctx }
+func (noopObserver) OnDecryptEnd(context.Context, string, error, time.Duration, int) {}
+func (noopObserver) OnKMSCall(context.Context, string, string, map[string]string, error, time.Duration) {
+}
+
 // AWSAEAD is an implementation of the AEAD interface which performs
 // cryptographic operations remotely via the AWS KMS service using a specific
-// key URI.
+// key URI. It also implements ContextAEAD.
 type AWSAEAD struct {
-	ctx                   context.Context
 	keyURI                string
 	kms                   Cryptable
 	encryptionContextName EncryptionContextName
+	requestTimeout        time.Duration
+	observer              ClientObserver
 }
 
 // newAWSAEAD returns a new AWSAEAD instance.
@@ -41,26 +96,72 @@ type AWSAEAD struct {
 //	aws-kms://arn:<partition>:kms:<region>:[<path>]
 //
 // See http://docs.aws.amazon.com/general/latest/gr/aws-arns-and-namespaces.html.
-func newAWSAEAD(ctx context.Context, keyURI string, kms Cryptable, name EncryptionContextName) *AWSAEAD {
+//
+// requestTimeout bounds each individual KMS call made through Encrypt or
+// Decrypt; a value of 0 means no timeout is applied beyond whatever the
+// caller's own context carries. observer may be nil, in which case calls
+// are not reported anywhere.
+func newAWSAEAD(keyURI string, kms Cryptable, name EncryptionContextName, requestTimeout time.Duration, observer ClientObserver) *AWSAEAD {
+	if observer == nil {
+		observer = noopObserver{}
+	}
 	return &AWSAEAD{
-		ctx:                   ctx,
 		keyURI:                keyURI,
 		kms:                   kms,
 		encryptionContextName: name,
+		requestTimeout:        requestTimeout,
+		observer:              observer,
 	}
 }
 
+// withRequestTimeout derives a context bounded by a.requestTimeout from
+// ctx, if one was configured. The returned cancel func must always be
+// called by the caller.
+func (a *AWSAEAD) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.requestTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, a.requestTimeout)
+}
+
 // Encrypt encrypts the plaintext with associatedData.
+//
+// It is equivalent to EncryptContext(context.Background(), plaintext, associatedData).
+// Prefer EncryptContext directly when the call should be bound to a
+// request-scoped context, for example to cancel a slow KMS call rather than
+// block the caller indefinitely.
 func (a *AWSAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	return a.EncryptContext(context.Background(), plaintext, associatedData)
+}
+
+// EncryptContext encrypts the plaintext with associatedData, using ctx to
+// bound the underlying KMS call.
+func (a *AWSAEAD) EncryptContext(ctx context.Context, plaintext, associatedData []byte) ([]byte, error) {
+	ctx, cancel := a.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx = a.observer.OnEncryptStart(ctx, a.keyURI)
+	start := time.Now()
+	ciphertext, err := a.encrypt(ctx, plaintext, associatedData)
+	a.observer.OnEncryptEnd(ctx, a.keyURI, err, time.Since(start), len(ciphertext))
+	return ciphertext, err
+}
+
+func (a *AWSAEAD) encrypt(ctx context.Context, plaintext, associatedData []byte) ([]byte, error) {
 	req := &kms.EncryptInput{
 		KeyId:     &a.keyURI,
 		Plaintext: plaintext,
 	}
+	var encryptionContext map[string]string
 	if len(associatedData) > 0 {
 		ad := hex.EncodeToString(associatedData)
-		req.EncryptionContext = map[string]string{a.encryptionContextName.String(): ad}
+		encryptionContext = map[string]string{a.encryptionContextName.String(): ad}
+		req.EncryptionContext = encryptionContext
 	}
-	resp, err := a.kms.Encrypt(a.ctx, req)
+
+	start := time.Now()
+	resp, err := a.kms.Encrypt(ctx, req)
+	a.observer.OnKMSCall(ctx, "Encrypt", a.keyURI, encryptionContext, err, time.Since(start))
 	if err != nil {
 		return nil, err
 	}
@@ -68,16 +169,43 @@ func (a *AWSAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
 }
 
 // Decrypt decrypts the ciphertext and verifies the associated data.
+//
+// It is equivalent to DecryptContext(context.Background(), ciphertext, associatedData).
+// Prefer DecryptContext directly when the call should be bound to a
+// request-scoped context, for example to cancel a slow KMS call rather than
+// block the caller indefinitely.
 func (a *AWSAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	return a.DecryptContext(context.Background(), ciphertext, associatedData)
+}
+
+// DecryptContext decrypts the ciphertext and verifies the associated data,
+// using ctx to bound the underlying KMS call.
+func (a *AWSAEAD) DecryptContext(ctx context.Context, ciphertext, associatedData []byte) ([]byte, error) {
+	ctx, cancel := a.withRequestTimeout(ctx)
+	defer cancel()
+
+	ctx = a.observer.OnDecryptStart(ctx, a.keyURI)
+	start := time.Now()
+	plaintext, err := a.decrypt(ctx, ciphertext, associatedData)
+	a.observer.OnDecryptEnd(ctx, a.keyURI, err, time.Since(start), len(plaintext))
+	return plaintext, err
+}
+
+func (a *AWSAEAD) decrypt(ctx context.Context, ciphertext, associatedData []byte) ([]byte, error) {
 	req := &kms.DecryptInput{
 		KeyId:          &a.keyURI,
 		CiphertextBlob: ciphertext,
 	}
+	var encryptionContext map[string]string
 	if len(associatedData) > 0 {
 		ad := hex.EncodeToString(associatedData)
-		req.EncryptionContext = map[string]string{a.encryptionContextName.String(): ad}
+		encryptionContext = map[string]string{a.encryptionContextName.String(): ad}
+		req.EncryptionContext = encryptionContext
 	}
-	resp, err := a.kms.Decrypt(a.ctx, req)
+
+	start := time.Now()
+	resp, err := a.kms.Decrypt(ctx, req)
+	a.observer.OnKMSCall(ctx, "Decrypt", a.keyURI, encryptionContext, err, time.Since(start))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeCryptable echoes its input back and records whether the context of
+// the most recent call carried a deadline.
+type fakeCryptable struct {
+	sawDeadline bool
+}
+
+func (f *fakeCryptable) Encrypt(ctx context.Context, params *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	_, f.sawDeadline = ctx.Deadline()
+	return &kms.EncryptOutput{CiphertextBlob: params.Plaintext}, nil
+}
+
+func (f *fakeCryptable) Decrypt(ctx context.Context, params *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	_, f.sawDeadline = ctx.Deadline()
+	return &kms.DecryptOutput{Plaintext: params.CiphertextBlob}, nil
+}
+
+func TestAWSAEADImplementsContextAEAD(t *testing.T) {
+	var _ ContextAEAD = newAWSAEAD("aws-kms://key", &fakeCryptable{}, AssociatedData, 0, nil)
+}
+
+func TestEncryptContextPropagatesCallerContext(t *testing.T) {
+	fc := &fakeCryptable{}
+	a := newAWSAEAD("aws-kms://key", fc, AssociatedData, 0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := a.EncryptContext(ctx, []byte("pt"), nil); err != nil {
+		t.Fatalf("EncryptContext() err = %v, want nil", err)
+	}
+	if !fc.sawDeadline {
+		t.Error("EncryptContext() did not propagate the caller's deadline to the KMS call")
+	}
+}
+
+func TestRequestTimeoutAppliedWhenCallerContextHasNoDeadline(t *testing.T) {
+	fc := &fakeCryptable{}
+	a := newAWSAEAD("aws-kms://key", fc, AssociatedData, time.Minute, nil)
+
+	if _, err := a.Encrypt([]byte("pt"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	if !fc.sawDeadline {
+		t.Error("Encrypt() did not apply the configured request timeout to a context.Background() call")
+	}
+
+	if _, err := a.Decrypt([]byte("ct"), nil); err != nil {
+		t.Fatalf("Decrypt() err = %v, want nil", err)
+	}
+	if !fc.sawDeadline {
+		t.Error("Decrypt() did not apply the configured request timeout to a context.Background() call")
+	}
+}
+
+// recordingObserver records every call made to it, for assertions in
+// TestClientObserverIsNotifiedOfEncryptAndDecrypt.
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) OnEncryptStart(ctx context.Context, keyURI string) context.Context {
+	o.events = append(o.events, "EncryptStart:"+keyURI)
+	return ctx
+}
+
+func (o *recordingObserver) OnEncryptEnd(_ context.Context, keyURI string, err error, _ time.Duration, ciphertextBytes int) {
+	o.events = append(o.events, fmt.Sprintf("EncryptEnd:%s:%v:%d", keyURI, err, ciphertextBytes))
+}
+
+func (o *recordingObserver) OnDecryptStart(ctx context.Context, keyURI string) context.Context {
+	o.events = append(o.events, "DecryptStart:"+keyURI)
+	return ctx
+}
+
+func (o *recordingObserver) OnDecryptEnd(_ context.Context, keyURI string, err error, _ time.Duration, plaintextBytes int) {
+	o.events = append(o.events, fmt.Sprintf("DecryptEnd:%s:%v:%d", keyURI, err, plaintextBytes))
+}
+
+func (o *recordingObserver) OnKMSCall(_ context.Context, op, keyURI string, _ map[string]string, err error, _ time.Duration) {
+	o.events = append(o.events, fmt.Sprintf("KMSCall:%s:%s:%v", op, keyURI, err))
+}
+
+func TestClientObserverIsNotifiedOfEncryptAndDecrypt(t *testing.T) {
+	fc := &fakeCryptable{}
+	observer := &recordingObserver{}
+	a := newAWSAEAD("aws-kms://key", fc, AssociatedData, 0, observer)
+
+	ct, err := a.Encrypt([]byte("pt"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	if _, err := a.Decrypt(ct, nil); err != nil {
+		t.Fatalf("Decrypt() err = %v, want nil", err)
+	}
+
+	want := []string{
+		"EncryptStart:aws-kms://key",
+		"KMSCall:Encrypt:aws-kms://key:<nil>",
+		"EncryptEnd:aws-kms://key:<nil>:2",
+		"DecryptStart:aws-kms://key",
+		"KMSCall:Decrypt:aws-kms://key:<nil>",
+		"DecryptEnd:aws-kms://key:<nil>:2",
+	}
+	if len(observer.events) != len(want) {
+		t.Fatalf("observer.events = %v, want %v", observer.events, want)
+	}
+	for i, w := range want {
+		if observer.events[i] != w {
+			t.Errorf("observer.events[%d] = %q, want %q", i, observer.events[i], w)
+		}
+	}
+}
+
+func TestNilObserverDefaultsToNoop(t *testing.T) {
+	fc := &fakeCryptable{}
+	a := newAWSAEAD("aws-kms://key", fc, AssociatedData, 0, nil)
+	if _, err := a.Encrypt([]byte("pt"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	awskmscreds "github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/credentials"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+// defaultAssumedRoleRefreshBefore is how long before expiry WithAssumeRole
+// and WithWebIdentityRoleFromEnv proactively refresh assumed-role
+// credentials, via awskmscreds.ExpiringProvider.
+const defaultAssumedRoleRefreshBefore = 5 * time.Minute
+
+const awsPrefix = "aws-kms://"
+
+// EncryptionContextName controls which key name is used when placing the
+// hex-encoded associated data into a KMS request's EncryptionContext.
+type EncryptionContextName int
+
+const (
+	// AssociatedData uses "associatedData" as the EncryptionContext key
+	// name. This is the default, and matches the other Tink language
+	// implementations.
+	AssociatedData EncryptionContextName = iota
+)
+
+// String returns the EncryptionContext key name.
+func (e EncryptionContextName) String() string {
+	return "associatedData"
+}
+
+// Cryptable is the subset of the AWS KMS API that AWSAEAD depends on. It is
+// satisfied by *kms.Client from the AWS SDK for Go V2.
+type Cryptable interface {
+	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// STSClient is the subset of the AWS STS API needed by WithAssumeRole and
+// WithWebIdentityRoleFromEnv to exchange ambient credentials for the target
+// role's credentials. It is satisfied by *sts.Client from the AWS SDK for
+// Go V2; pass a fake to WithSTSClient to exercise either option without
+// making real STS calls.
+type STSClient interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// AWSClient represents a client that connects to the AWS KMS backend.
+type AWSClient struct {
+	keyURIPrefix          string
+	kms                   Cryptable
+	encryptionContextName EncryptionContextName
+	requestTimeout        time.Duration
+	observer              ClientObserver
+}
+
+// clientConfig accumulates the settings applied by ClientOption before the
+// underlying aws.Config and kms.Client are built.
+type clientConfig struct {
+	credentialsProvider   aws.CredentialsProvider
+	credentialsResolver   func(ctx context.Context, cc *clientConfig) (aws.CredentialsProvider, error)
+	stsClient             STSClient
+	encryptionContextName EncryptionContextName
+	requestTimeout        time.Duration
+	retryer               aws.Retryer
+	observer              ClientObserver
+	kmsOptions            []func(*kms.Options)
+}
+
+// ambientSTSClient returns the STSClient to use for resolving AssumeRole or
+// web identity credentials: cc.stsClient if WithSTSClient injected one (as
+// tests do), or else a *sts.Client built from the ambient default AWS
+// config, sharing whatever retryer was set via WithRetryer.
+func (cc *clientConfig) ambientSTSClient(ctx context.Context) (STSClient, error) {
+	if cc.stsClient != nil {
+		return cc.stsClient, nil
+	}
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if cc.retryer != nil {
+		loadOpts = append(loadOpts, awsconfig.WithRetryer(func() aws.Retryer { return cc.retryer }))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("awskms: loading default AWS config: %v", err)
+	}
+	return sts.NewFromConfig(cfg), nil
+}
+
+// ClientOption configures a AWSClient created by NewClientWithOptions.
+type ClientOption func(*clientConfig) error
+
+// WithCredentialPath instantiates the client with the credentials in
+// credentialPath, which may be either a CSV file as downloaded from the AWS
+// IAM console, or an INI-formatted shared credentials file. If
+// credentialPath is empty, the default credentials are used.
+func WithCredentialPath(ctx context.Context, credentialPath string) ClientOption {
+	return func(c *clientConfig) error {
+		if len(credentialPath) == 0 {
+			return nil
+		}
+		provider, err := credentialsProviderFromPath(credentialPath)
+		if err != nil {
+			return fmt.Errorf("awskms: loading credentials from %q: %v", credentialPath, err)
+		}
+		c.credentialsProvider = provider
+		return nil
+	}
+}
+
+// WithCredentialsProvider instantiates the client using an arbitrary
+// aws.CredentialsProvider, for callers that already have one (e.g. built by
+// another part of their application, or from a library this package doesn't
+// know about).
+func WithCredentialsProvider(provider aws.CredentialsProvider) ClientOption {
+	return func(c *clientConfig) error {
+		c.credentialsProvider = provider
+		return nil
+	}
+}
+
+// WithAssumeRole instantiates the client with credentials obtained by
+// assuming roleARN, under the given sessionName. Unless WithSTSClient
+// supplies one, the AssumeRole call is made with an STS client built from
+// the ambient default AWS config (environment, shared config file, IMDS,
+// etc.), sharing any retryer configured via WithRetryer. The resulting
+// provider is wrapped in an awskmscreds.ExpiringProvider so that credentials
+// are refreshed defaultAssumedRoleRefreshBefore their expiry, rather than
+// paying a synchronous AssumeRole call's latency on the request that
+// arrives just after they expire.
+func WithAssumeRole(roleARN, sessionName string, opts ...func(*stscreds.AssumeRoleOptions)) ClientOption {
+	return func(c *clientConfig) error {
+		c.credentialsResolver = func(ctx context.Context, cc *clientConfig) (aws.CredentialsProvider, error) {
+			stsClient, err := cc.ambientSTSClient(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("awskms: building STS client for AssumeRole: %v", err)
+			}
+			provider := stscreds.NewAssumeRoleProvider(stsClient, roleARN, func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = sessionName
+				for _, opt := range opts {
+					opt(o)
+				}
+			})
+			return awskmscreds.NewExpiringProvider(provider, defaultAssumedRoleRefreshBefore), nil
+		}
+		return nil
+	}
+}
+
+// WithSTSClient overrides the STS client used by WithAssumeRole and
+// WithWebIdentityRoleFromEnv, instead of one built from the ambient default
+// AWS config. This exists mainly so tests can exercise those options
+// against a fake STS backend without making real network calls.
+func WithSTSClient(client STSClient) ClientOption {
+	return func(c *clientConfig) error {
+		c.stsClient = client
+		return nil
+	}
+}
+
+// WithWebIdentityRoleFromEnv instantiates the client with credentials
+// obtained via STS AssumeRoleWithWebIdentity, using the role ARN and web
+// identity token file path from the AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE environment variables. These are the
+// variables the EKS IAM Roles for Service Accounts (IRSA) feature injects
+// into pods, so this lets workloads running in EKS authenticate without
+// materializing any static credentials. As with WithAssumeRole, the
+// resulting provider is wrapped in an awskmscreds.ExpiringProvider so
+// credentials are refreshed defaultAssumedRoleRefreshBefore their expiry.
+func WithWebIdentityRoleFromEnv() ClientOption {
+	return func(c *clientConfig) error {
+		roleARN := os.Getenv("AWS_ROLE_ARN")
+		tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		if roleARN == "" || tokenFile == "" {
+			return fmt.Errorf("awskms: AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE must both be set")
+		}
+		c.credentialsResolver = func(ctx context.Context, cc *clientConfig) (aws.CredentialsProvider, error) {
+			stsClient, err := cc.ambientSTSClient(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("awskms: building STS client for web identity: %v", err)
+			}
+			provider := stscreds.NewWebIdentityRoleProvider(stsClient, roleARN, stscreds.IdentityTokenFile(tokenFile))
+			return awskmscreds.NewExpiringProvider(provider, defaultAssumedRoleRefreshBefore), nil
+		}
+		return nil
+	}
+}
+
+// WithRequestTimeout bounds each individual KMS call (Encrypt or Decrypt)
+// made through AEAD primitives obtained from this client to d. Without it, a call made through the plain tink.AEAD
+// interface (which has no context of its own) runs with
+// context.Background() and can block indefinitely if KMS is slow or
+// unreachable; callers that need more control over an individual call can
+// use ContextAEAD instead.
+func WithRequestTimeout(d time.Duration) ClientOption {
+	return func(c *clientConfig) error {
+		c.requestTimeout = d
+		return nil
+	}
+}
+
+// WithRetryer sets the aws.Retryer used for calls made by the underlying
+// KMS client, overriding the SDK's default retry and backoff behavior, for
+// example to fail fast instead of retrying on throttling.
+func WithRetryer(retryer aws.Retryer) ClientOption {
+	return func(c *clientConfig) error {
+		c.retryer = retryer
+		return nil
+	}
+}
+
+// WithObserver registers an observer that is notified of every Encrypt and
+// Decrypt call made through AEAD primitives obtained from this client, and
+// of the underlying KMS calls they make. See ClientObserver and the
+// awskms/otel subpackage for an OpenTelemetry-based implementation.
+func WithObserver(o ClientObserver) ClientOption {
+	return func(c *clientConfig) error {
+		c.observer = o
+		return nil
+	}
+}
+
+// WithKMSOptions applies optFns to the kms.Options used to build the
+// underlying *kms.Client, for configuration this package doesn't otherwise
+// expose, such as registering additional smithy-go middleware (e.g. the
+// trace-context propagation middleware in the awskms/otel subpackage).
+func WithKMSOptions(optFns ...func(*kms.Options)) ClientOption {
+	return func(c *clientConfig) error {
+		c.kmsOptions = append(c.kmsOptions, optFns...)
+		return nil
+	}
+}
+
+// WithEC2InstanceMetadata instantiates the client with credentials fetched
+// from the EC2 Instance Metadata Service (IMDS), for callers running on an
+// EC2 instance with an attached instance profile.
+func WithEC2InstanceMetadata() ClientOption {
+	return func(c *clientConfig) error {
+		c.credentialsProvider = ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+			o.Client = imds.New(imds.Options{})
+		})
+		return nil
+	}
+}
+
+// credentialsProviderFromPath loads static credentials from a CSV file as
+// downloaded from the AWS IAM console, or an INI-formatted shared
+// credentials file, based on the file's extension.
+func credentialsProviderFromPath(path string) (aws.CredentialsProvider, error) {
+	if strings.HasSuffix(path, ".csv") {
+		return staticCredentialsFromCSV(path)
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithSharedConfigFiles(nil),
+		awsconfig.WithSharedCredentialsFiles([]string{path}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Credentials, nil
+}
+
+// staticCredentialsFromCSV parses the "Access key ID"/"Secret access key"
+// columns out of a CSV credentials file as downloaded from the AWS IAM
+// console, and returns them as a static credentials provider.
+func staticCredentialsFromCSV(path string) (aws.CredentialsProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	row, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials row: %v", err)
+	}
+	col := make(map[string]string, len(header))
+	for i, name := range header {
+		if i < len(row) {
+			col[strings.TrimSpace(name)] = row[i]
+		}
+	}
+	accessKeyID, ok := col["Access key ID"]
+	if !ok {
+		return nil, fmt.Errorf("missing %q column", "Access key ID")
+	}
+	secretAccessKey, ok := col["Secret access key"]
+	if !ok {
+		return nil, fmt.Errorf("missing %q column", "Secret access key")
+	}
+	return credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, ""), nil
+}
+
+// NewClientWithOptions returns a new AWS KMS client which will handle keys
+// whose URIs start with uriPrefix. uriPrefix must have the following
+// format:
+//
+//	aws-kms://arn:<partition>:kms:<region>:[<path>]
+func NewClientWithOptions(ctx context.Context, uriPrefix string, opts ...ClientOption) (*AWSClient, error) {
+	if !strings.HasPrefix(strings.ToLower(uriPrefix), awsPrefix) {
+		return nil, fmt.Errorf("uriPrefix must start with %q, but got %q", awsPrefix, uriPrefix)
+	}
+
+	cc := &clientConfig{encryptionContextName: AssociatedData}
+	for _, opt := range opts {
+		if err := opt(cc); err != nil {
+			return nil, err
+		}
+	}
+	if cc.credentialsResolver != nil {
+		provider, err := cc.credentialsResolver(ctx, cc)
+		if err != nil {
+			return nil, err
+		}
+		cc.credentialsProvider = provider
+	}
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if cc.credentialsProvider != nil {
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(cc.credentialsProvider))
+	}
+	if cc.retryer != nil {
+		loadOpts = append(loadOpts, awsconfig.WithRetryer(func() aws.Retryer { return cc.retryer }))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("awskms: loading AWS config: %v", err)
+	}
+
+	return &AWSClient{
+		keyURIPrefix:          uriPrefix,
+		kms:                   kms.NewFromConfig(cfg, cc.kmsOptions...),
+		encryptionContextName: cc.encryptionContextName,
+		requestTimeout:        cc.requestTimeout,
+		observer:              cc.observer,
+	}, nil
+}
+
+// Supported returns true if this client does support keyURI.
+func (c *AWSClient) Supported(keyURI string) bool {
+	return strings.HasPrefix(keyURI, c.keyURIPrefix)
+}
+
+// GetAEAD returns an AEAD backed by the AWS KMS key identified by keyURI.
+func (c *AWSClient) GetAEAD(keyURI string) (tink.AEAD, error) {
+	if !c.Supported(keyURI) {
+		return nil, fmt.Errorf("keyURI must start with prefix %q, but got %q", c.keyURIPrefix, keyURI)
+	}
+	uri := strings.TrimPrefix(keyURI, awsPrefix)
+	return newAWSAEAD(uri, c.kms, c.encryptionContextName, c.requestTimeout, c.observer), nil
+}
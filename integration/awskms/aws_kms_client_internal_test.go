@@ -0,0 +1,169 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// fakeSTSClient is a mock STS backend satisfying STSClient, used to
+// exercise WithAssumeRole and WithWebIdentityRoleFromEnv end to end
+// (including the actual credential Retrieve call) without making real
+// network calls.
+type fakeSTSClient struct {
+	accessKeyID, secretAccessKey, sessionToken string
+
+	assumeRoleCalled                bool
+	assumeRoleWithWebIdentityCalled bool
+}
+
+func (f *fakeSTSClient) AssumeRole(_ context.Context, _ *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.assumeRoleCalled = true
+	return &sts.AssumeRoleOutput{Credentials: f.credentials()}, nil
+}
+
+func (f *fakeSTSClient) AssumeRoleWithWebIdentity(_ context.Context, _ *sts.AssumeRoleWithWebIdentityInput, _ ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	f.assumeRoleWithWebIdentityCalled = true
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: f.credentials()}, nil
+}
+
+func (f *fakeSTSClient) credentials() *types.Credentials {
+	return &types.Credentials{
+		AccessKeyId:     &f.accessKeyID,
+		SecretAccessKey: &f.secretAccessKey,
+		SessionToken:    &f.sessionToken,
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestWithAssumeRoleUsesInjectedSTSClient(t *testing.T) {
+	fake := &fakeSTSClient{accessKeyID: "id", secretAccessKey: "secret", sessionToken: "token"}
+	cc := &clientConfig{}
+	if err := WithSTSClient(fake)(cc); err != nil {
+		t.Fatalf("WithSTSClient() err = %v, want nil", err)
+	}
+	if err := WithAssumeRole("arn:aws:iam::123456789012:role/test-role", "session")(cc); err != nil {
+		t.Fatalf("WithAssumeRole() err = %v, want nil", err)
+	}
+
+	provider, err := cc.credentialsResolver(context.Background(), cc)
+	if err != nil {
+		t.Fatalf("credentialsResolver() err = %v, want nil", err)
+	}
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if creds.AccessKeyID != "id" {
+		t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, "id")
+	}
+	if !fake.assumeRoleCalled {
+		t.Error("AssumeRole was never called on the injected STS client")
+	}
+}
+
+func TestWithWebIdentityRoleFromEnvUsesInjectedSTSClient(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("test-token"), 0o600); err != nil {
+		t.Fatalf("WriteFile() err = %v, want nil", err)
+	}
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/test-role")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+
+	fake := &fakeSTSClient{accessKeyID: "id", secretAccessKey: "secret", sessionToken: "token"}
+	cc := &clientConfig{}
+	if err := WithSTSClient(fake)(cc); err != nil {
+		t.Fatalf("WithSTSClient() err = %v, want nil", err)
+	}
+	if err := WithWebIdentityRoleFromEnv()(cc); err != nil {
+		t.Fatalf("WithWebIdentityRoleFromEnv() err = %v, want nil", err)
+	}
+
+	provider, err := cc.credentialsResolver(context.Background(), cc)
+	if err != nil {
+		t.Fatalf("credentialsResolver() err = %v, want nil", err)
+	}
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if creds.AccessKeyID != "id" {
+		t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, "id")
+	}
+	if !fake.assumeRoleWithWebIdentityCalled {
+		t.Error("AssumeRoleWithWebIdentity was never called on the injected STS client")
+	}
+}
+
+func TestWithWebIdentityRoleFromEnvRequiresBothEnvVars(t *testing.T) {
+	cc := &clientConfig{}
+	if err := WithWebIdentityRoleFromEnv()(cc); err == nil {
+		t.Error("WithWebIdentityRoleFromEnv() err = nil, want error when AWS_ROLE_ARN/AWS_WEB_IDENTITY_TOKEN_FILE are unset")
+	}
+}
+
+// TestWithEC2InstanceMetadataRetrievesCredentialsFromIMDS points the
+// instance metadata client at a fake IMDS server and verifies
+// WithEC2InstanceMetadata's provider actually retrieves credentials from
+// it, rather than only checking that the option applies without error.
+func TestWithEC2InstanceMetadataRetrievesCredentialsFromIMDS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			fmt.Fprint(w, "fake-imds-token")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "test-role")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/test-role":
+			json.NewEncoder(w).Encode(map[string]string{
+				"Code":            "Success",
+				"AccessKeyId":     "id",
+				"SecretAccessKey": "secret",
+				"Token":           "token",
+				"Expiration":      time.Now().Add(time.Hour).Format(time.RFC3339),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+
+	cc := &clientConfig{}
+	if err := WithEC2InstanceMetadata()(cc); err != nil {
+		t.Fatalf("WithEC2InstanceMetadata() err = %v, want nil", err)
+	}
+
+	creds, err := cc.credentialsProvider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if creds.AccessKeyID != "id" {
+		t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, "id")
+	}
+}
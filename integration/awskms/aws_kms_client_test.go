@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms"
+)
+
+// fakeSTS is a mock STS AssumeRole backend, used to exercise
+// stscreds.AssumeRoleProvider (the building block behind WithAssumeRole)
+// without making real network calls.
+type fakeSTS struct {
+	accessKeyID, secretAccessKey, sessionToken string
+}
+
+func (f *fakeSTS) AssumeRole(_ context.Context, params *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return &sts.AssumeRoleOutput{
+		Credentials: &types.Credentials{
+			AccessKeyId:     &f.accessKeyID,
+			SecretAccessKey: &f.secretAccessKey,
+			SessionToken:    &f.sessionToken,
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+// TestWithCredentialsProviderAssumeRole exercises the WithCredentialsProvider
+// escape hatch with an stscreds.AssumeRoleProvider backed by a mock STS,
+// the same composition WithAssumeRole builds internally against the
+// ambient default AWS config.
+func TestWithCredentialsProviderAssumeRole(t *testing.T) {
+	provider := stscreds.NewAssumeRoleProvider(&fakeSTS{accessKeyID: "id", secretAccessKey: "secret", sessionToken: "token"}, "arn:aws:iam::123456789012:role/test-role")
+	client, err := awskms.NewClientWithOptions(context.Background(), "aws-kms://arn:aws:kms:us-east-2:", awskms.WithCredentialsProvider(provider))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+	}
+	if client == nil {
+		t.Fatal("NewClientWithOptions() returned nil client")
+	}
+}
+
+func TestWithCredentialsProvider(t *testing.T) {
+	provider := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+	})
+	client, err := awskms.NewClientWithOptions(context.Background(), "aws-kms://arn:aws:kms:us-east-2:", awskms.WithCredentialsProvider(provider))
+	if err != nil {
+		t.Fatalf("NewClientWithOptions() err = %v, want nil", err)
+	}
+	if client == nil {
+		t.Fatal("NewClientWithOptions() returned nil client")
+	}
+}
+
+func TestNewClientWithOptionsRejectsBadPrefix(t *testing.T) {
+	if _, err := awskms.NewClientWithOptions(context.Background(), "not-an-aws-kms-uri"); err == nil {
+		t.Error("NewClientWithOptions() err = nil, want error for invalid prefix")
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+const (
+	defaultCacheTTL             = time.Hour
+	defaultMaxBytesPerDEK       = 1 << 30 // 1 GiB.
+	defaultMaxMessagesPerDEK    = 1 << 32 // Stay under the GCM random-nonce birthday bound.
+	defaultMaxDecryptCacheSize  = 1000
+	dekSizeBytes                = 32 // AES-256.
+	gcmNonceSizeBytes           = 12
+	wrappedKeyLengthPrefixBytes = 4
+)
+
+// CacheOption configures a CachingAEAD.
+type CacheOption func(*CachingAEAD)
+
+// WithTTL bounds how long a cached DEK, encrypting or decrypting, may be
+// reused before it must be refreshed (for encryption) or evicted (for
+// decryption).
+func WithTTL(ttl time.Duration) CacheOption {
+	return func(c *CachingAEAD) { c.ttl = ttl }
+}
+
+// WithMaxBytesEncrypted rotates the active encryption DEK once it has been
+// used to encrypt at least n bytes of plaintext in total.
+func WithMaxBytesEncrypted(n uint64) CacheOption {
+	return func(c *CachingAEAD) { c.maxBytesPerDEK = n }
+}
+
+// WithMaxMessages rotates the active encryption DEK once it has encrypted n
+// messages. Without it the limit is 2^32, to stay under the birthday bound
+// for randomly chosen AES-GCM nonces under a single key.
+func WithMaxMessages(n uint64) CacheOption {
+	return func(c *CachingAEAD) { c.maxMessagesPerDEK = n }
+}
+
+// WithMaxDecryptCacheSize bounds the number of distinct wrapped DEKs kept
+// in the decryption cache; the oldest entry is evicted once the limit is
+// reached.
+func WithMaxDecryptCacheSize(n int) CacheOption {
+	return func(c *CachingAEAD) { c.maxDecryptCacheSize = n }
+}
+
+// cachedDEK is a data encryption key together with its KMS-wrapped form, the
+// associatedData that wrap was bound under, and the usage counters that
+// decide when it must be rotated or evicted.
+type cachedDEK struct {
+	plaintext      []byte
+	wrapped        []byte
+	associatedData []byte
+	createdAt      time.Time
+	bytesEncrypted uint64
+	messages       uint64
+}
+
+func (d *cachedDEK) expired(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(d.createdAt) >= ttl
+}
+
+// CachingAEAD wraps a base tink.AEAD (typically an AWSAEAD) to turn it into
+// a KMS-envelope AEAD that amortizes KMS calls across many messages: a
+// single data encryption key (DEK) is wrapped with the base AEAD once and
+// then reused, subject to a TTL and cryptographic usage limits, to encrypt
+// or decrypt locally with AES-GCM. Ciphertexts are self-describing:
+//
+//	[ wrapped_dek_len (4 bytes, big-endian) | wrapped_dek | nonce (12 bytes) | aes_gcm_ciphertext ]
+//
+// so that Decrypt can unwrap the right DEK without any out-of-band state.
+// associatedData is bound both to the wrap (via the base AEAD's own
+// associated-data/encryption-context handling) and to the local AES-GCM
+// ciphertext.
+type CachingAEAD struct {
+	base tink.AEAD
+
+	ttl                 time.Duration
+	maxBytesPerDEK      uint64
+	maxMessagesPerDEK   uint64
+	maxDecryptCacheSize int
+
+	mu           sync.Mutex
+	active       *cachedDEK
+	decryptCache map[string]*cachedDEK
+	decryptOrder []string
+}
+
+// NewCachingAEAD returns a CachingAEAD that wraps base.
+func NewCachingAEAD(base tink.AEAD, opts ...CacheOption) *CachingAEAD {
+	c := &CachingAEAD{
+		base:                base,
+		ttl:                 defaultCacheTTL,
+		maxBytesPerDEK:      defaultMaxBytesPerDEK,
+		maxMessagesPerDEK:   defaultMaxMessagesPerDEK,
+		maxDecryptCacheSize: defaultMaxDecryptCacheSize,
+		decryptCache:        make(map[string]*cachedDEK),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Encrypt encrypts plaintext with associatedData, reusing the active DEK if
+// it is still within its TTL and usage limits and was wrapped under the same
+// associatedData, and otherwise generating and wrapping a fresh one via the
+// base AEAD. A DEK's wrap is bound to the associatedData of the message that
+// caused it to be generated (see wrapNewDEK), so it cannot be reused across
+// messages with different associatedData. The KMS call made when a fresh DEK
+// must be wrapped happens outside c.mu, so concurrent Encrypt calls are never
+// serialized behind one in-flight KMS request; on a race, more than one
+// goroutine may redundantly wrap a new DEK, the same tradeoff
+// cacheForDecryptLocked already makes on the decrypt path.
+func (c *CachingAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	c.mu.Lock()
+	dek := c.active
+	needsRotation := dek == nil || dek.expired(c.ttl) ||
+		!bytes.Equal(dek.associatedData, associatedData) ||
+		dek.bytesEncrypted+uint64(len(plaintext)) > c.maxBytesPerDEK ||
+		dek.messages+1 > c.maxMessagesPerDEK
+	if !needsRotation {
+		dek.bytesEncrypted += uint64(len(plaintext))
+		dek.messages++
+	}
+	c.mu.Unlock()
+
+	if needsRotation {
+		var err error
+		dek, err = c.wrapNewDEK(associatedData)
+		if err != nil {
+			return nil, err
+		}
+		dek.bytesEncrypted = uint64(len(plaintext))
+		dek.messages = 1
+
+		c.mu.Lock()
+		c.active = dek
+		c.cacheForDecryptLocked(dek)
+		c.mu.Unlock()
+	}
+
+	nonce := make([]byte, gcmNonceSizeBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("awskms: generating nonce: %v", err)
+	}
+	ct, err := gcmSeal(dek.plaintext, nonce, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, wrappedKeyLengthPrefixBytes+len(dek.wrapped)+len(nonce)+len(ct))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(dek.wrapped)))
+	out = append(out, dek.wrapped...)
+	out = append(out, nonce...)
+	out = append(out, ct...)
+	return out, nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt. If the wrapped DEK
+// embedded in ciphertext was seen recently under the same associatedData,
+// the cached plaintext DEK is reused and no KMS call is made; otherwise it
+// is unwrapped via the base AEAD (which verifies associatedData against the
+// wrap itself) and cached for subsequent calls.
+func (c *CachingAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(ciphertext) < wrappedKeyLengthPrefixBytes {
+		return nil, fmt.Errorf("awskms: ciphertext too short")
+	}
+	wrappedLen := binary.BigEndian.Uint32(ciphertext[:wrappedKeyLengthPrefixBytes])
+	rest := ciphertext[wrappedKeyLengthPrefixBytes:]
+	if uint64(len(rest)) < uint64(wrappedLen)+gcmNonceSizeBytes {
+		return nil, fmt.Errorf("awskms: ciphertext too short")
+	}
+	wrapped := rest[:wrappedLen]
+	nonce := rest[wrappedLen : wrappedLen+gcmNonceSizeBytes]
+	ct := rest[wrappedLen+gcmNonceSizeBytes:]
+
+	dek, err := c.dekForDecrypt(wrapped, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dek, nonce, ct, associatedData)
+}
+
+// dekForDecrypt returns the plaintext DEK for wrapped, bound to
+// associatedData. A decrypt-cache hit is only honored if its entry was
+// itself wrapped under the same associatedData: the cache key is derived
+// from both, but entries are double-checked here too since a cache key
+// collision must never be allowed to bypass the associated-data binding.
+func (c *CachingAEAD) dekForDecrypt(wrapped, associatedData []byte) ([]byte, error) {
+	h := decryptCacheKey(wrapped, associatedData)
+
+	c.mu.Lock()
+	if d, ok := c.decryptCache[h]; ok && !d.expired(c.ttl) && bytes.Equal(d.associatedData, associatedData) {
+		c.mu.Unlock()
+		return d.plaintext, nil
+	}
+	c.mu.Unlock()
+
+	plaintext, err := c.base.Decrypt(wrapped, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cacheForDecryptLocked(&cachedDEK{plaintext: plaintext, wrapped: wrapped, associatedData: associatedData, createdAt: time.Now()})
+	c.mu.Unlock()
+	return plaintext, nil
+}
+
+// wrapNewDEK generates a fresh random DEK and wraps it via the base AEAD,
+// binding associatedData to the wrap the same way a direct call to the
+// base AEAD would. The returned cachedDEK may only be reused for messages
+// presenting this same associatedData.
+func (c *CachingAEAD) wrapNewDEK(associatedData []byte) (*cachedDEK, error) {
+	plaintext := make([]byte, dekSizeBytes)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, fmt.Errorf("awskms: generating data key: %v", err)
+	}
+	wrapped, err := c.base.Encrypt(plaintext, associatedData)
+	if err != nil {
+		return nil, fmt.Errorf("awskms: wrapping data key: %v", err)
+	}
+	return &cachedDEK{plaintext: plaintext, wrapped: wrapped, associatedData: associatedData, createdAt: time.Now()}, nil
+}
+
+// cacheForDecryptLocked adds dek to the decryption cache, evicting the
+// oldest entry first if the cache is full. c.mu must be held.
+func (c *CachingAEAD) cacheForDecryptLocked(dek *cachedDEK) {
+	key := decryptCacheKey(dek.wrapped, dek.associatedData)
+	if _, ok := c.decryptCache[key]; ok {
+		return
+	}
+	if len(c.decryptOrder) >= c.maxDecryptCacheSize {
+		oldest := c.decryptOrder[0]
+		c.decryptOrder = c.decryptOrder[1:]
+		delete(c.decryptCache, oldest)
+	}
+	c.decryptCache[key] = dek
+	c.decryptOrder = append(c.decryptOrder, key)
+}
+
+// decryptCacheKey derives the decrypt cache key from both wrapped and
+// associatedData, since a cachedDEK's wrap is only valid for the
+// associatedData it was generated under.
+func decryptCacheKey(wrapped, associatedData []byte) string {
+	h := sha256.New()
+	h.Write(wrapped)
+	h.Write([]byte{0}) // separator: avoids ambiguity at the wrapped/associatedData boundary.
+	h.Write(associatedData)
+	return string(h.Sum(nil))
+}
+
+func gcmSeal(key, nonce, plaintext, associatedData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, associatedData), nil
+}
+
+func gcmOpen(key, nonce, ciphertext, associatedData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ciphertext, associatedData)
+}
@@ -0,0 +1,286 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingAEAD wraps a tink.AEAD-shaped fake KMS "base" AEAD and counts how
+// many times Encrypt/Decrypt (i.e. simulated KMS calls) were made.
+type countingAEAD struct {
+	encryptCalls atomic.Int64
+	decryptCalls atomic.Int64
+	latency      time.Duration
+}
+
+func (f *countingAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	f.encryptCalls.Add(1)
+	time.Sleep(f.latency)
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		out[i] = b ^ 0x5a
+	}
+	out = append(out, associatedData...)
+	return out, nil
+}
+
+func (f *countingAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	f.decryptCalls.Add(1)
+	time.Sleep(f.latency)
+	if len(ciphertext) < len(associatedData) || !bytes.Equal(ciphertext[len(ciphertext)-len(associatedData):], associatedData) {
+		return nil, fmt.Errorf("countingAEAD: associated data mismatch")
+	}
+	body := ciphertext[:len(ciphertext)-len(associatedData)]
+	out := make([]byte, len(body))
+	for i, b := range body {
+		out[i] = b ^ 0x5a
+	}
+	return out, nil
+}
+
+func TestCachingAEADEncryptDecryptRoundTrip(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base)
+
+	plaintext := []byte("secret message")
+	associatedData := []byte("context")
+	ct, err := c.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	pt, err := c.Decrypt(ct, associatedData)
+	if err != nil {
+		t.Fatalf("Decrypt() err = %v, want nil", err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", pt, plaintext)
+	}
+}
+
+func TestCachingAEADReusesDEKAcrossMessages(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base)
+
+	const messages = 10
+	for i := 0; i < messages; i++ {
+		ct, err := c.Encrypt([]byte(fmt.Sprintf("message %d", i)), nil)
+		if err != nil {
+			t.Fatalf("Encrypt() err = %v, want nil", err)
+		}
+		if _, err := c.Decrypt(ct, nil); err != nil {
+			t.Fatalf("Decrypt() err = %v, want nil", err)
+		}
+	}
+	if got := base.encryptCalls.Load(); got != 1 {
+		t.Errorf("base.Encrypt called %d times across %d cached messages, want 1", got, messages)
+	}
+	// Encrypt already seeded the decrypt cache with the DEK it wrapped, so
+	// decrypting messages encrypted under it costs zero KMS calls.
+	if got := base.decryptCalls.Load(); got != 0 {
+		t.Errorf("base.Decrypt called %d times across %d cached messages, want 0", got, messages)
+	}
+}
+
+func TestCachingAEADRotatesAfterMaxMessages(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base, WithMaxMessages(2))
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Encrypt([]byte("m"), nil); err != nil {
+			t.Fatalf("Encrypt() err = %v, want nil", err)
+		}
+	}
+	// ceil(5/2) = 3 distinct DEKs should have been wrapped.
+	if got, want := base.encryptCalls.Load(), int64(3); got != want {
+		t.Errorf("base.Encrypt called %d times, want %d", got, want)
+	}
+}
+
+func TestCachingAEADRotatesAfterTTLExpires(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base, WithTTL(time.Millisecond))
+
+	if _, err := c.Encrypt([]byte("m"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.Encrypt([]byte("m"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	if got, want := base.encryptCalls.Load(), int64(2); got != want {
+		t.Errorf("base.Encrypt called %d times, want %d", got, want)
+	}
+}
+
+func TestCachingAEADDoesNotReuseDEKAcrossDifferentAssociatedData(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base, WithMaxMessages(2))
+
+	ct1, err := c.Encrypt([]byte("m1"), []byte("ad1"))
+	if err != nil {
+		t.Fatalf("Encrypt(ad1) err = %v, want nil", err)
+	}
+	ct2, err := c.Encrypt([]byte("m2"), []byte("ad2"))
+	if err != nil {
+		t.Fatalf("Encrypt(ad2) err = %v, want nil", err)
+	}
+	// Different associatedData must force a fresh DEK wrap each time, even
+	// though WithMaxMessages(2) would otherwise let the active DEK survive
+	// a second message.
+	if got, want := base.encryptCalls.Load(), int64(2); got != want {
+		t.Errorf("base.Encrypt called %d times, want %d (one DEK per distinct associatedData)", got, want)
+	}
+
+	if _, err := c.Decrypt(ct1, []byte("ad1")); err != nil {
+		t.Fatalf("Decrypt(ct1, ad1) err = %v, want nil", err)
+	}
+	if _, err := c.Decrypt(ct2, []byte("ad2")); err != nil {
+		t.Fatalf("Decrypt(ct2, ad2) err = %v, want nil", err)
+	}
+}
+
+// TestCachingAEADDecryptSurvivesDecryptCacheEvictionWithPerMessageAD
+// reproduces the scenario where messages sharing a DEK rotation window each
+// carry their own associatedData: once the decrypt cache entry for an
+// earlier DEK is evicted, decrypting a later message must still succeed
+// with exactly the associatedData it was encrypted under, since each DEK's
+// wrap is bound to the associatedData of the message that generated it.
+func TestCachingAEADDecryptSurvivesDecryptCacheEvictionWithPerMessageAD(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base, WithMaxMessages(1), WithMaxDecryptCacheSize(1))
+
+	ct1, err := c.Encrypt([]byte("m1"), []byte("ad1"))
+	if err != nil {
+		t.Fatalf("Encrypt(ad1) err = %v, want nil", err)
+	}
+	ct2, err := c.Encrypt([]byte("m2"), []byte("ad2"))
+	if err != nil {
+		t.Fatalf("Encrypt(ad2) err = %v, want nil", err)
+	}
+	// ct1's decrypt-cache entry has been evicted by ct2's, forcing a cold
+	// base.Decrypt call bound to ad1's own, correct associatedData.
+	pt1, err := c.Decrypt(ct1, []byte("ad1"))
+	if err != nil {
+		t.Fatalf("Decrypt(ct1, ad1) err = %v, want nil", err)
+	}
+	if string(pt1) != "m1" {
+		t.Errorf("Decrypt(ct1, ad1) = %q, want %q", pt1, "m1")
+	}
+	pt2, err := c.Decrypt(ct2, []byte("ad2"))
+	if err != nil {
+		t.Fatalf("Decrypt(ct2, ad2) err = %v, want nil", err)
+	}
+	if string(pt2) != "m2" {
+		t.Errorf("Decrypt(ct2, ad2) = %q, want %q", pt2, "m2")
+	}
+}
+
+// TestCachingAEADEncryptDoesNotSerializeOnDEKRotation verifies that Encrypt
+// does not hold its lock across the KMS call made to wrap a fresh DEK:
+// concurrent calls that each force rotation (WithMaxMessages(1)) against a
+// slow base AEAD must overlap, not run back-to-back.
+func TestCachingAEADEncryptDoesNotSerializeOnDEKRotation(t *testing.T) {
+	const (
+		goroutines = 5
+		latency    = 200 * time.Millisecond
+	)
+	base := &countingAEAD{latency: latency}
+	c := NewCachingAEAD(base, WithMaxMessages(1))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Encrypt([]byte("m"), nil); err != nil {
+				t.Errorf("Encrypt() err = %v, want nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Fully serialized would take goroutines*latency; overlapping should
+	// stay well under half of that.
+	if max := time.Duration(goroutines) * latency / 2; elapsed >= max {
+		t.Errorf("Encrypt calls took %v, want < %v (expected concurrent DEK wraps, not serialized ones)", elapsed, max)
+	}
+}
+
+func TestCachingAEADEvictsOldestDecryptEntry(t *testing.T) {
+	base := &countingAEAD{}
+	c := NewCachingAEAD(base, WithMaxMessages(1), WithMaxDecryptCacheSize(1))
+
+	ct1, err := c.Encrypt([]byte("m1"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	ct2, err := c.Encrypt([]byte("m2"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	// ct1's DEK has been evicted from the decrypt cache, so decrypting it
+	// now requires a fresh base.Decrypt call.
+	calls := base.decryptCalls.Load()
+	if _, err := c.Decrypt(ct1, nil); err != nil {
+		t.Fatalf("Decrypt(ct1) err = %v, want nil", err)
+	}
+	if got := base.decryptCalls.Load(); got != calls+1 {
+		t.Errorf("base.Decrypt called %d times decrypting evicted ct1, want %d", got, calls+1)
+	}
+	if _, err := c.Decrypt(ct2, nil); err != nil {
+		t.Fatalf("Decrypt(ct2) err = %v, want nil", err)
+	}
+}
+
+// BenchmarkCachingAEAD_Encrypt and BenchmarkUncachedEncrypt demonstrate the
+// latency and "KMS call" volume reduction from caching: with a 5ms
+// simulated KMS round trip, the caching wrapper pays it once instead of
+// once per message.
+func BenchmarkCachingAEAD_Encrypt(b *testing.B) {
+	base := &countingAEAD{latency: 5 * time.Millisecond}
+	c := NewCachingAEAD(base)
+	plaintext := []byte("benchmark message")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Encrypt(plaintext, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(base.encryptCalls.Load()), "kms-calls")
+}
+
+func BenchmarkUncachedEncrypt(b *testing.B) {
+	base := &countingAEAD{latency: 5 * time.Millisecond}
+	plaintext := []byte("benchmark message")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := base.Encrypt(plaintext, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(base.encryptCalls.Load()), "kms-calls")
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+// Package credentials provides helpers for working with AWS credential
+// providers, for use alongside the awskms package's ClientOptions.
+package credentials
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ExpiringProvider wraps an aws.CredentialsProvider (typically one that
+// assumes a role, such as those returned by stscreds) and refreshes the
+// credentials refreshBefore their expiry, rather than waiting until they
+// have actually expired. This avoids a request that arrives just after
+// expiry from paying the latency of a synchronous refresh.
+type ExpiringProvider struct {
+	base          aws.CredentialsProvider
+	refreshBefore time.Duration
+
+	mu     sync.Mutex
+	cached aws.Credentials
+}
+
+// NewExpiringProvider returns an ExpiringProvider that refreshes
+// credentials obtained from base refreshBefore they expire.
+func NewExpiringProvider(base aws.CredentialsProvider, refreshBefore time.Duration) *ExpiringProvider {
+	return &ExpiringProvider{base: base, refreshBefore: refreshBefore}
+}
+
+// Retrieve returns the cached credentials if they are still valid for at
+// least refreshBefore, otherwise it fetches and caches fresh ones from the
+// wrapped provider.
+func (p *ExpiringProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached.HasKeys() && !p.cached.Expired() && (!p.cached.CanExpire || time.Until(p.cached.Expires) > p.refreshBefore) {
+		return p.cached, nil
+	}
+	creds, err := p.base.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	p.cached = creds
+	return creds, nil
+}
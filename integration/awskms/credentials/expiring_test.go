@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package credentials_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/credentials"
+)
+
+// countingProvider is a fake aws.CredentialsProvider that returns credentials
+// expiring expiresIn from each Retrieve call, and counts how many times
+// Retrieve was actually invoked.
+type countingProvider struct {
+	calls     int
+	expiresIn time.Duration
+}
+
+func (p *countingProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	p.calls++
+	return aws.Credentials{
+		AccessKeyID:     fmt.Sprintf("key-%d", p.calls),
+		SecretAccessKey: "secret",
+		CanExpire:       true,
+		Expires:         time.Now().Add(p.expiresIn),
+	}, nil
+}
+
+func TestExpiringProviderCachesUntilRefreshWindow(t *testing.T) {
+	base := &countingProvider{expiresIn: time.Hour}
+	p := credentials.NewExpiringProvider(base, time.Minute)
+
+	first, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	second, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if second.AccessKeyID != first.AccessKeyID {
+		t.Errorf("second Retrieve() = %q, want cached %q", second.AccessKeyID, first.AccessKeyID)
+	}
+	if base.calls != 1 {
+		t.Errorf("base.Retrieve called %d times, want 1", base.calls)
+	}
+}
+
+func TestExpiringProviderRefreshesBeforeExpiry(t *testing.T) {
+	base := &countingProvider{expiresIn: 10 * time.Millisecond}
+	p := credentials.NewExpiringProvider(base, 5*time.Millisecond)
+
+	if _, err := p.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	// The cached credentials are still technically unexpired, but are
+	// within the 5ms refresh window, so this call must refresh rather than
+	// return the stale ones.
+	time.Sleep(7 * time.Millisecond)
+	if _, err := p.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if base.calls != 2 {
+		t.Errorf("base.Retrieve called %d times, want 2 (refresh before expiry window)", base.calls)
+	}
+}
+
+func TestExpiringProviderRefreshesAfterExpiry(t *testing.T) {
+	base := &countingProvider{expiresIn: 5 * time.Millisecond}
+	p := credentials.NewExpiringProvider(base, 0)
+
+	if _, err := p.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := p.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() err = %v, want nil", err)
+	}
+	if base.calls != 2 {
+		t.Errorf("base.Retrieve called %d times, want 2 (refresh after expiry)", base.calls)
+	}
+}
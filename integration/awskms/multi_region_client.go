@@ -0,0 +1,290 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tink-crypto/tink-go/v2/core/registry"
+	"github.com/tink-crypto/tink-go/v2/tink"
+)
+
+const defaultCircuitBreakerCooldown = time.Minute
+
+// RegionObserver receives per-region success/error notifications from a
+// multi-region client, for callers that want to export them as metrics.
+type RegionObserver interface {
+	// OnRegionSuccess is called after a call to region for op ("Encrypt" or
+	// "Decrypt") succeeds.
+	OnRegionSuccess(region, op string)
+	// OnRegionError is called after a call to region for op fails with err.
+	OnRegionError(region, op string, err error)
+}
+
+// Option configures a multi-region client created by NewMultiRegionClient.
+type Option func(*multiRegionSettings)
+
+type multiRegionSettings struct {
+	clientOptions []ClientOption
+	cooldown      time.Duration
+	observer      RegionObserver
+}
+
+// WithRegionClientOptions applies opts to every per-region AWSClient the
+// multi-region client builds (for example, to share credential or timeout
+// options across all regions).
+func WithRegionClientOptions(opts ...ClientOption) Option {
+	return func(s *multiRegionSettings) { s.clientOptions = append(s.clientOptions, opts...) }
+}
+
+// WithCircuitBreakerCooldown sets how long a region is skipped after a
+// failed call before it is tried again. The default is one minute.
+func WithCircuitBreakerCooldown(d time.Duration) Option {
+	return func(s *multiRegionSettings) { s.cooldown = d }
+}
+
+// WithRegionObserver registers an observer that is notified of the outcome
+// of every per-region call the multi-region client makes.
+func WithRegionObserver(o RegionObserver) Option {
+	return func(s *multiRegionSettings) { s.observer = o }
+}
+
+// regionHealth tracks the circuit-breaker state for a single region.
+type regionHealth struct {
+	mu          sync.Mutex
+	bannedUntil time.Time
+}
+
+func (h *regionHealth) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Now().After(h.bannedUntil)
+}
+
+func (h *regionHealth) demote(cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bannedUntil = time.Now().Add(cooldown)
+}
+
+func (h *regionHealth) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bannedUntil = time.Time{}
+}
+
+// regionClient is one region's KMS client for a multi-region KMS key.
+type regionClient struct {
+	region string
+	keyURI string
+	client *AWSClient
+	health *regionHealth
+}
+
+// multiRegionClient is a registry.KMSClient backed by one AWSClient per
+// region of a multi-region KMS key, returned by NewMultiRegionClient.
+type multiRegionClient struct {
+	keyURIs  map[string]bool // every region's key URI, for Supported.
+	primary  *regionClient
+	replicas []*regionClient
+	cooldown time.Duration
+	observer RegionObserver
+}
+
+// NewMultiRegionClient returns a registry.KMSClient for a multi-region AWS
+// KMS key: primaryURI is used for Encrypt, and replicaURIs are additional
+// regional replicas of the same multi-region key that Decrypt can fall back
+// to if the region holding primaryURI is unavailable. All URIs must refer
+// to the same multi-region key (same key ID, different region in the ARN).
+//
+// A region that returns an error is demoted for a cooldown period (see
+// WithCircuitBreakerCooldown) and skipped by subsequent calls until it
+// elapses, so a single unavailable region doesn't add latency to every
+// call.
+func NewMultiRegionClient(ctx context.Context, primaryURI string, replicaURIs []string, opts ...Option) (registry.KMSClient, error) {
+	settings := &multiRegionSettings{cooldown: defaultCircuitBreakerCooldown}
+	for _, opt := range opts {
+		opt(settings)
+	}
+
+	primary, err := newRegionClient(ctx, primaryURI, settings.clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("awskms: building primary region client for %q: %v", primaryURI, err)
+	}
+	keyURIs := map[string]bool{primaryURI: true}
+	replicas := make([]*regionClient, 0, len(replicaURIs))
+	for _, uri := range replicaURIs {
+		rc, err := newRegionClient(ctx, uri, settings.clientOptions)
+		if err != nil {
+			return nil, fmt.Errorf("awskms: building replica region client for %q: %v", uri, err)
+		}
+		replicas = append(replicas, rc)
+		keyURIs[uri] = true
+	}
+
+	return &multiRegionClient{
+		keyURIs:  keyURIs,
+		primary:  primary,
+		replicas: replicas,
+		cooldown: settings.cooldown,
+		observer: settings.observer,
+	}, nil
+}
+
+func newRegionClient(ctx context.Context, keyURI string, opts []ClientOption) (*regionClient, error) {
+	client, err := NewClientWithOptions(ctx, keyURI, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &regionClient{
+		region: regionFromKeyURI(keyURI),
+		keyURI: keyURI,
+		client: client,
+		health: &regionHealth{},
+	}, nil
+}
+
+// regionFromKeyURI extracts the region component from a key URI of the form
+// "aws-kms://arn:<partition>:kms:<region>:...". It returns the full URI if
+// the region can't be parsed out, so it always returns something usable
+// for logging and metrics.
+func regionFromKeyURI(keyURI string) string {
+	parts := strings.Split(strings.TrimPrefix(keyURI, awsPrefix), ":")
+	if len(parts) >= 4 && parts[2] == "kms" {
+		return parts[3]
+	}
+	return keyURI
+}
+
+// Supported returns true if keyURI is one of the regional key URIs this
+// client was built with.
+func (m *multiRegionClient) Supported(keyURI string) bool {
+	return m.keyURIs[keyURI]
+}
+
+// GetAEAD returns an AEAD for keyURI. Since all regional key URIs refer to
+// the same multi-region key, the returned AEAD routes Encrypt to the
+// primary region (falling back to replicas if the primary is unavailable)
+// and Decrypt to each region in turn until one succeeds.
+func (m *multiRegionClient) GetAEAD(keyURI string) (tink.AEAD, error) {
+	if !m.Supported(keyURI) {
+		return nil, fmt.Errorf("awskms: keyURI %q is not one of the regions this multi-region client was configured with", keyURI)
+	}
+	return &multiRegionAEAD{client: m}, nil
+}
+
+// all returns every regionClient, primary first.
+func (m *multiRegionClient) all() []*regionClient {
+	return append([]*regionClient{m.primary}, m.replicas...)
+}
+
+// multiRegionAEAD is the tink.AEAD returned by multiRegionClient.GetAEAD.
+type multiRegionAEAD struct {
+	client *multiRegionClient
+}
+
+// Encrypt encrypts plaintext using the primary region, falling back to
+// replicas in order if the primary is circuit-broken or fails.
+func (a *multiRegionAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	return a.client.tryRegions(a.client.orderedForEncrypt(), "Encrypt", func(aead tink.AEAD) ([]byte, error) {
+		return aead.Encrypt(plaintext, associatedData)
+	})
+}
+
+// Decrypt decrypts ciphertext, preferring the region whose key ARN appears
+// to be embedded in the ciphertext blob (a heuristic: AWS KMS does not
+// document the ciphertext blob format, so this is best-effort) and
+// otherwise trying every region in order until one succeeds. This lets a
+// ciphertext produced by one region's multi-region key be decrypted by any
+// replica during a regional outage.
+func (a *multiRegionAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	return a.client.tryRegions(a.client.orderedForDecrypt(ciphertext), "Decrypt", func(aead tink.AEAD) ([]byte, error) {
+		return aead.Decrypt(ciphertext, associatedData)
+	})
+}
+
+// orderedForEncrypt returns the regions to try, primary first.
+func (m *multiRegionClient) orderedForEncrypt() []*regionClient {
+	return m.all()
+}
+
+// orderedForDecrypt returns the regions to try, preferring whichever
+// region's key ARN appears as a substring of ciphertext.
+func (m *multiRegionClient) orderedForDecrypt(ciphertext []byte) []*regionClient {
+	all := m.all()
+	for i, rc := range all {
+		if i == 0 {
+			continue
+		}
+		if bytes.Contains(ciphertext, []byte(strings.TrimPrefix(rc.keyURI, awsPrefix))) {
+			reordered := append([]*regionClient{rc}, all[:i]...)
+			return append(reordered, all[i+1:]...)
+		}
+	}
+	return all
+}
+
+// tryRegions calls op against each region in order, skipping regions that
+// are currently circuit-broken unless every region is, and returns the
+// first success. Each outcome is reported to the health tracker and, if
+// configured, the RegionObserver.
+func (m *multiRegionClient) tryRegions(regions []*regionClient, opName string, op func(tink.AEAD) ([]byte, error)) ([]byte, error) {
+	ordered := make([]*regionClient, 0, len(regions))
+	var skipped []*regionClient
+	for _, rc := range regions {
+		if rc.health.healthy() {
+			ordered = append(ordered, rc)
+		} else {
+			skipped = append(skipped, rc)
+		}
+	}
+	// If every region is circuit-broken, try them anyway rather than
+	// failing outright: a cooldown is a latency optimization, not a hard
+	// outage guarantee.
+	if len(ordered) == 0 {
+		ordered = skipped
+	}
+
+	var lastErr error
+	for _, rc := range ordered {
+		aead, err := rc.client.GetAEAD(rc.keyURI)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		out, err := op(aead)
+		if err != nil {
+			lastErr = err
+			rc.health.demote(m.cooldown)
+			if m.observer != nil {
+				m.observer.OnRegionError(rc.region, opName, err)
+			}
+			continue
+		}
+		rc.health.reset()
+		if m.observer != nil {
+			m.observer.OnRegionSuccess(rc.region, opName)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("awskms: %s failed in all %d regions tried, last error: %v", opName, len(ordered), lastErr)
+}
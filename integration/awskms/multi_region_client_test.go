@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package awskms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// flakyCryptable fails every call until it has failed failUntil times, then
+// succeeds for the rest of the test, like a region recovering from an
+// outage.
+type flakyCryptable struct {
+	failUntil int
+	calls     int
+}
+
+func (f *flakyCryptable) Encrypt(_ context.Context, params *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	f.calls++
+	if f.calls <= f.failUntil {
+		return nil, errors.New("flakyCryptable: simulated region outage")
+	}
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte(*params.KeyId+":"), params.Plaintext...)}, nil
+}
+
+func (f *flakyCryptable) Decrypt(_ context.Context, params *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	f.calls++
+	if f.calls <= f.failUntil {
+		return nil, errors.New("flakyCryptable: simulated region outage")
+	}
+	return &kms.DecryptOutput{Plaintext: params.CiphertextBlob}, nil
+}
+
+func newTestRegionClient(uri string, kms Cryptable) *regionClient {
+	return &regionClient{
+		region: regionFromKeyURI(uri),
+		keyURI: uri,
+		client: &AWSClient{keyURIPrefix: uri, kms: kms, encryptionContextName: AssociatedData},
+		health: &regionHealth{},
+	}
+}
+
+// countingObserver records the per-region outcomes reported to it.
+type countingObserver struct {
+	successes, errs map[string]int
+}
+
+func newCountingObserver() *countingObserver {
+	return &countingObserver{successes: make(map[string]int), errs: make(map[string]int)}
+}
+
+func (o *countingObserver) OnRegionSuccess(region, _ string)        { o.successes[region]++ }
+func (o *countingObserver) OnRegionError(region, _ string, _ error) { o.errs[region]++ }
+
+func TestMultiRegionClientSupported(t *testing.T) {
+	primaryURI := "aws-kms://arn:aws:kms:us-east-1:123456789012:key/mrk-1"
+	replicaURI := "aws-kms://arn:aws:kms:us-west-2:123456789012:key/mrk-1"
+	m := &multiRegionClient{
+		keyURIs:  map[string]bool{primaryURI: true, replicaURI: true},
+		primary:  newTestRegionClient(primaryURI, &flakyCryptable{}),
+		replicas: []*regionClient{newTestRegionClient(replicaURI, &flakyCryptable{})},
+		cooldown: time.Minute,
+	}
+	if !m.Supported(primaryURI) || !m.Supported(replicaURI) {
+		t.Error("Supported() = false for a configured region, want true")
+	}
+	if m.Supported("aws-kms://arn:aws:kms:eu-west-1:123456789012:key/mrk-1") {
+		t.Error("Supported() = true for an unconfigured region, want false")
+	}
+}
+
+func TestMultiRegionClientEncryptFallsBackToReplicaOnPrimaryFailure(t *testing.T) {
+	primaryURI := "aws-kms://arn:aws:kms:us-east-1:123456789012:key/mrk-1"
+	replicaURI := "aws-kms://arn:aws:kms:us-west-2:123456789012:key/mrk-1"
+	observer := newCountingObserver()
+	m := &multiRegionClient{
+		keyURIs:  map[string]bool{primaryURI: true, replicaURI: true},
+		primary:  newTestRegionClient(primaryURI, &flakyCryptable{failUntil: 100}), // always fails
+		replicas: []*regionClient{newTestRegionClient(replicaURI, &flakyCryptable{})},
+		cooldown: time.Minute,
+		observer: observer,
+	}
+	aead, err := m.GetAEAD(primaryURI)
+	if err != nil {
+		t.Fatalf("GetAEAD() err = %v, want nil", err)
+	}
+	if _, err := aead.Encrypt([]byte("pt"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	if observer.errs["us-east-1"] != 1 {
+		t.Errorf("observer saw %d errors for us-east-1, want 1", observer.errs["us-east-1"])
+	}
+	if observer.successes["us-west-2"] != 1 {
+		t.Errorf("observer saw %d successes for us-west-2, want 1", observer.successes["us-west-2"])
+	}
+}
+
+func TestMultiRegionClientCircuitBreakerSkipsDemotedRegion(t *testing.T) {
+	primaryURI := "aws-kms://arn:aws:kms:us-east-1:123456789012:key/mrk-1"
+	replicaURI := "aws-kms://arn:aws:kms:us-west-2:123456789012:key/mrk-1"
+	primaryKMS := &flakyCryptable{failUntil: 100}
+	m := &multiRegionClient{
+		keyURIs:  map[string]bool{primaryURI: true, replicaURI: true},
+		primary:  newTestRegionClient(primaryURI, primaryKMS),
+		replicas: []*regionClient{newTestRegionClient(replicaURI, &flakyCryptable{})},
+		cooldown: time.Hour,
+	}
+	aead, err := m.GetAEAD(primaryURI)
+	if err != nil {
+		t.Fatalf("GetAEAD() err = %v, want nil", err)
+	}
+	// First call fails over to the replica and demotes the primary.
+	if _, err := aead.Encrypt([]byte("pt"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	callsAfterFirst := primaryKMS.calls
+	// A second call should skip the still-demoted primary entirely.
+	if _, err := aead.Encrypt([]byte("pt2"), nil); err != nil {
+		t.Fatalf("Encrypt() err = %v, want nil", err)
+	}
+	if primaryKMS.calls != callsAfterFirst {
+		t.Errorf("primary was called again while demoted: calls went from %d to %d", callsAfterFirst, primaryKMS.calls)
+	}
+}
+
+func TestMultiRegionClientDecryptPrefersRegionEmbeddedInCiphertext(t *testing.T) {
+	primaryURI := "aws-kms://arn:aws:kms:us-east-1:123456789012:key/mrk-1"
+	replicaURI := "aws-kms://arn:aws:kms:us-west-2:123456789012:key/mrk-1"
+	replicaKMS := &flakyCryptable{}
+	m := &multiRegionClient{
+		keyURIs:  map[string]bool{primaryURI: true, replicaURI: true},
+		primary:  newTestRegionClient(primaryURI, &flakyCryptable{failUntil: 100}),
+		replicas: []*regionClient{newTestRegionClient(replicaURI, replicaKMS)},
+		cooldown: time.Minute,
+	}
+	// A ciphertext blob that "happens" to embed the replica's key ARN
+	// should be tried against the replica first, without the primary ever
+	// being called.
+	ciphertext := []byte(fmt.Sprintf("%s:blob", strings.TrimPrefix(replicaURI, awsPrefix)))
+	aead, err := m.GetAEAD(primaryURI)
+	if err != nil {
+		t.Fatalf("GetAEAD() err = %v, want nil", err)
+	}
+	if _, err := aead.Decrypt(ciphertext, nil); err != nil {
+		t.Fatalf("Decrypt() err = %v, want nil", err)
+	}
+	if replicaKMS.calls != 1 {
+		t.Errorf("replica called %d times, want exactly 1 (preferred first, succeeded immediately)", replicaKMS.calls)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package otel
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const tracePropagationMiddlewareID = "awskms.otel.TracePropagation"
+
+// WithKMSAPIOption returns a func(*kms.Options) that injects the trace
+// context active on each request (typically the span started by an
+// Observer) into the outgoing KMS HTTP request as W3C trace-context
+// headers, so that any OpenTelemetry-aware collector on the AWS side can
+// correlate the call with the caller's trace.
+//
+// Pass it to awskms.WithKMSOptions when building a client:
+//
+//	awskms.NewClientWithOptions(ctx, uriPrefix,
+//		awskms.WithObserver(otel.NewObserver(tracer)),
+//		awskms.WithKMSOptions(otel.WithKMSAPIOption()))
+func WithKMSAPIOption() func(*kms.Options) {
+	return func(o *kms.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *smithymiddleware.Stack) error {
+			return stack.Serialize.Add(tracePropagationMiddleware{}, smithymiddleware.After)
+		})
+	}
+}
+
+// tracePropagationMiddleware injects the caller's trace context into the
+// outgoing smithy HTTP request during serialization.
+type tracePropagationMiddleware struct{}
+
+func (tracePropagationMiddleware) ID() string { return tracePropagationMiddlewareID }
+
+func (tracePropagationMiddleware) HandleSerialize(ctx context.Context, in smithymiddleware.SerializeInput, next smithymiddleware.SerializeHandler) (smithymiddleware.SerializeOutput, smithymiddleware.Metadata, error) {
+	if req, ok := in.Request.(*smithyhttp.Request); ok {
+		otelapi.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	return next.HandleSerialize(ctx, in)
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+// Package otel provides an OpenTelemetry-based awskms.ClientObserver, and a
+// KMS client option that propagates the caller's active trace onto outgoing
+// KMS requests.
+package otel
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/otel"
+
+// redactedEncryptionContextValue replaces EncryptionContext values in
+// exported span attributes: the values are hex-encoded associated data
+// supplied by the caller and may be sensitive, so only the key names are
+// reported, never the values themselves.
+const redactedEncryptionContextValue = "REDACTED"
+
+// Observer is an awskms.ClientObserver that reports each Encrypt/Decrypt
+// call as an OpenTelemetry span named "awskms.Encrypt" / "awskms.Decrypt",
+// and each KMS call it makes as an event on that span.
+type Observer struct {
+	tracer trace.Tracer
+}
+
+// NewObserver returns an Observer that creates spans with tracer. If tracer
+// is nil, the global TracerProvider's default tracer is used.
+func NewObserver(tracer trace.Tracer) *Observer {
+	if tracer == nil {
+		tracer = otelapi.Tracer(instrumentationName)
+	}
+	return &Observer{tracer: tracer}
+}
+
+// OnEncryptStart starts the "awskms.Encrypt" span.
+func (o *Observer) OnEncryptStart(ctx context.Context, keyURI string) context.Context {
+	return o.startSpan(ctx, "awskms.Encrypt", keyURI)
+}
+
+// OnEncryptEnd ends the span started by OnEncryptStart.
+func (o *Observer) OnEncryptEnd(ctx context.Context, _ string, err error, _ time.Duration, ciphertextBytes int) {
+	endSpan(ctx, err, attribute.Int("aws.kms.ciphertext_bytes", ciphertextBytes))
+}
+
+// OnDecryptStart starts the "awskms.Decrypt" span.
+func (o *Observer) OnDecryptStart(ctx context.Context, keyURI string) context.Context {
+	return o.startSpan(ctx, "awskms.Decrypt", keyURI)
+}
+
+// OnDecryptEnd ends the span started by OnDecryptStart.
+func (o *Observer) OnDecryptEnd(ctx context.Context, _ string, err error, _ time.Duration, plaintextBytes int) {
+	endSpan(ctx, err, attribute.Int("aws.kms.plaintext_bytes", plaintextBytes))
+}
+
+// OnKMSCall records the individual KMS API call as an event on the span
+// started by OnEncryptStart/OnDecryptStart.
+func (o *Observer) OnKMSCall(ctx context.Context, op, _ string, encryptionContext map[string]string, err error, latency time.Duration) {
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.method", op),
+		attribute.Int64("aws.kms.call_latency_ms", latency.Milliseconds()),
+	}
+	for name := range encryptionContext {
+		attrs = append(attrs, attribute.String("aws.kms.encryption_context."+name, redactedEncryptionContextValue))
+	}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error.message", err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("aws.kms.call", trace.WithAttributes(attrs...))
+}
+
+func (o *Observer) startSpan(ctx context.Context, name, keyURI string) context.Context {
+	ctx, _ = o.tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("aws.kms.key_arn", keyURI),
+		attribute.String("aws.kms.region", regionFromARN(keyURI)),
+	))
+	return ctx
+}
+
+func endSpan(ctx context.Context, err error, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// regionFromARN extracts the region component from a KMS key ARN of the
+// form "arn:<partition>:kms:<region>:...". It returns "" if the region
+// can't be parsed out.
+func regionFromARN(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) >= 4 && parts[2] == "kms" {
+		return parts[3]
+	}
+	return ""
+}
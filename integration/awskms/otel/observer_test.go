@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms"
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/otel"
+)
+
+var _ awskms.ClientObserver = (*otel.Observer)(nil)
+
+const testKeyARN = "arn:aws:kms:us-east-1:123456789012:key/mrk-1"
+
+func newTestObserver(t *testing.T) (*otel.Observer, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return otel.NewObserver(tp.Tracer("test")), exporter
+}
+
+func TestObserverRecordsSuccessfulEncryptSpan(t *testing.T) {
+	o, exporter := newTestObserver(t)
+
+	ctx := o.OnEncryptStart(context.Background(), testKeyARN)
+	o.OnKMSCall(ctx, "Encrypt", testKeyARN, map[string]string{"associatedData": "deadbeef"}, nil, time.Millisecond)
+	o.OnEncryptEnd(ctx, testKeyARN, nil, time.Millisecond, 42)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "awskms.Encrypt" {
+		t.Errorf("span.Name = %q, want %q", span.Name, "awskms.Encrypt")
+	}
+	if span.Status.Code.String() == "Error" {
+		t.Errorf("span.Status = %v, want non-error", span.Status)
+	}
+
+	attrs := attrMap(span.Attributes)
+	if got, want := attrs["aws.kms.key_arn"], testKeyARN; got != want {
+		t.Errorf("aws.kms.key_arn = %q, want %q", got, want)
+	}
+	if got, want := attrs["aws.kms.region"], "us-east-1"; got != want {
+		t.Errorf("aws.kms.region = %q, want %q", got, want)
+	}
+	if got, want := attrs["aws.kms.ciphertext_bytes"], "42"; got != want {
+		t.Errorf("aws.kms.ciphertext_bytes = %q, want %q", got, want)
+	}
+
+	if len(span.Events) != 1 {
+		t.Fatalf("got %d events, want 1", len(span.Events))
+	}
+	eventAttrs := attrMap(span.Events[0].Attributes)
+	if got, want := eventAttrs["aws.kms.encryption_context.associatedData"], "REDACTED"; got != want {
+		t.Errorf("encryption context attribute = %q, want redacted value %q", got, want)
+	}
+}
+
+func TestObserverRecordsFailedDecryptSpan(t *testing.T) {
+	o, exporter := newTestObserver(t)
+	wantErr := errors.New("access denied")
+
+	ctx := o.OnDecryptStart(context.Background(), testKeyARN)
+	o.OnKMSCall(ctx, "Decrypt", testKeyARN, nil, wantErr, time.Millisecond)
+	o.OnDecryptEnd(ctx, testKeyARN, wantErr, time.Millisecond, 0)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Status.Code.String() != "Error" {
+		t.Errorf("span.Status = %v, want Error", spans[0].Status)
+	}
+}
+
+func attrMap(kvs []attribute.KeyValue) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+	return m
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package s3crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	gcmNonceSize  = 12
+	gcmTagBits    = 128
+	ctrIVSize     = 16
+	ctrHMACTagLen = sha256.Size
+
+	// ctrHMACKeySize is the size of each of the two keys derived from the
+	// DEK for the AES-CTR+HMAC content encryption algorithm.
+	ctrHMACKeySize = 32 // AES-256.
+)
+
+// ctrHMACKeyInfo is the HKDF info string that separates the derived AES-CTR
+// encryption key from the HMAC-SHA256 authentication key, so that neither
+// can be trivially confused with or substituted for the other.
+const ctrHMACKeyInfo = "s3crypto AES-CTR+HMAC-SHA256 key derivation"
+
+// deriveCTRHMACKeys derives independent encryption and authentication keys
+// from dek via HKDF-SHA256, rather than using dek directly for both AES-CTR
+// and HMAC-SHA256, so that a weakness in one primitive's use of the key
+// cannot be leveraged against the other.
+func deriveCTRHMACKeys(dek []byte) (encKey, macKey []byte, err error) {
+	keys := make([]byte, 2*ctrHMACKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dek, nil, []byte(ctrHMACKeyInfo)), keys); err != nil {
+		return nil, nil, fmt.Errorf("s3crypto: deriving AES-CTR/HMAC keys: %v", err)
+	}
+	return keys[:ctrHMACKeySize], keys[ctrHMACKeySize:], nil
+}
+
+// encryptBody encrypts plaintext under dek using the content-encryption
+// algorithm named by cekAlg, returning the ciphertext, the IV that was
+// used, and the authentication tag length in bits.
+func encryptBody(cekAlg string, dek, plaintext []byte) (ciphertext, iv []byte, tagLenBits int, err error) {
+	switch cekAlg {
+	case CEKAlgAESGCM:
+		return encryptAESGCM(dek, plaintext)
+	case CEKAlgAESCTRHMAC:
+		return encryptAESCTRHMAC(dek, plaintext)
+	default:
+		return nil, nil, 0, fmt.Errorf("s3crypto: unsupported content encryption algorithm %q", cekAlg)
+	}
+}
+
+// decryptBody reverses encryptBody.
+func decryptBody(cekAlg string, dek, ciphertext, iv []byte, tagLenBits int) ([]byte, error) {
+	switch cekAlg {
+	case CEKAlgAESGCM:
+		return decryptAESGCM(dek, ciphertext, iv, tagLenBits)
+	case CEKAlgAESCTRHMAC:
+		return decryptAESCTRHMAC(dek, ciphertext, iv)
+	default:
+		return nil, fmt.Errorf("s3crypto: unsupported content encryption algorithm %q", cekAlg)
+	}
+}
+
+func encryptAESGCM(dek, plaintext []byte) (ciphertext, iv []byte, tagLenBits int, err error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	iv = make([]byte, gcmNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, 0, err
+	}
+	return gcm.Seal(nil, iv, plaintext, nil), iv, gcmTagBits, nil
+}
+
+func decryptAESGCM(dek, ciphertext, iv []byte, tagLenBits int) ([]byte, error) {
+	if tagLenBits != gcmTagBits {
+		return nil, fmt.Errorf("s3crypto: unsupported GCM tag length %d bits", tagLenBits)
+	}
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, iv, ciphertext, nil)
+}
+
+func encryptAESCTRHMAC(dek, plaintext []byte) (ciphertext, iv []byte, tagLenBits int, err error) {
+	encKey, macKey, err := deriveCTRHMACKeys(dek)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	iv = make([]byte, ctrIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, 0, err
+	}
+	out := make([]byte, len(plaintext))
+	cipher.NewCTR(block, iv).XORKeyStream(out, plaintext)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(out)
+	return append(out, mac.Sum(nil)...), iv, ctrHMACTagLen * 8, nil
+}
+
+func decryptAESCTRHMAC(dek, ciphertext, iv []byte) ([]byte, error) {
+	if len(ciphertext) < ctrHMACTagLen {
+		return nil, fmt.Errorf("s3crypto: ciphertext too short for AES-CTR+HMAC")
+	}
+	body, tag := ciphertext[:len(ciphertext)-ctrHMACTagLen], ciphertext[len(ciphertext)-ctrHMACTagLen:]
+
+	encKey, macKey, err := deriveCTRHMACKeys(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(body)
+	if subtle.ConstantTimeCompare(mac.Sum(nil), tag) != 1 {
+		return nil, fmt.Errorf("s3crypto: AES-CTR+HMAC authentication failed")
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(body))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, body)
+	return plaintext, nil
+}
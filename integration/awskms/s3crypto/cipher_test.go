@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package s3crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveCTRHMACKeysAreIndependent(t *testing.T) {
+	dek := bytes.Repeat([]byte{0x42}, dekSizeBytes)
+	encKey, macKey, err := deriveCTRHMACKeys(dek)
+	if err != nil {
+		t.Fatalf("deriveCTRHMACKeys() err = %v, want nil", err)
+	}
+	if len(encKey) != ctrHMACKeySize || len(macKey) != ctrHMACKeySize {
+		t.Fatalf("got len(encKey)=%d, len(macKey)=%d, want %d each", len(encKey), len(macKey), ctrHMACKeySize)
+	}
+	if bytes.Equal(encKey, macKey) {
+		t.Error("encKey and macKey are equal, want independently derived keys")
+	}
+	if bytes.Equal(encKey, dek) || bytes.Equal(macKey, dek) {
+		t.Error("encKey or macKey equals the raw dek, want derived keys distinct from it")
+	}
+}
+
+func TestEncryptAESCTRHMACRejectsTamperedCiphertext(t *testing.T) {
+	dek := bytes.Repeat([]byte{0x7a}, dekSizeBytes)
+	ciphertext, iv, _, err := encryptAESCTRHMAC(dek, []byte("protect me"))
+	if err != nil {
+		t.Fatalf("encryptAESCTRHMAC() err = %v, want nil", err)
+	}
+	ciphertext[0] ^= 0xff
+
+	if _, err := decryptAESCTRHMAC(dek, ciphertext, iv); err == nil {
+		t.Error("decryptAESCTRHMAC() err = nil for tampered ciphertext, want authentication failure")
+	}
+}
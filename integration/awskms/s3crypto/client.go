@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package s3crypto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KeyManager is the subset of the AWS KMS API this package needs in order
+// to wrap and unwrap data encryption keys. It is satisfied by
+// *kms.Client from the AWS SDK for Go V2.
+type KeyManager interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+const dekSizeBytes = 32 // AES-256.
+
+// EncryptionClient encrypts objects before handing them to an ObjectStore,
+// using the "kms+context" envelope encryption scheme: a fresh DEK is
+// generated per object, wrapped with a KMS key, and stored alongside the
+// ciphertext in the object's user metadata.
+type EncryptionClient struct {
+	store  ObjectStore
+	kms    KeyManager
+	keyURI string
+	cekAlg string
+}
+
+// EncryptionOption configures an EncryptionClient.
+type EncryptionOption func(*EncryptionClient)
+
+// WithAESCTRHMAC switches the content-encryption algorithm from the
+// default, AES-GCM, to AES-CTR with a separate HMAC-SHA256 authentication
+// tag.
+func WithAESCTRHMAC() EncryptionOption {
+	return func(c *EncryptionClient) { c.cekAlg = CEKAlgAESCTRHMAC }
+}
+
+// NewEncryptionClient returns an EncryptionClient that wraps DEKs with the
+// KMS key identified by keyURI (a key ID, key ARN, or alias, as accepted by
+// kms.GenerateDataKeyInput.KeyId) and stores objects via store.
+func NewEncryptionClient(store ObjectStore, kmsClient KeyManager, keyURI string, opts ...EncryptionOption) *EncryptionClient {
+	c := &EncryptionClient{
+		store:  store,
+		kms:    kmsClient,
+		keyURI: keyURI,
+		cekAlg: CEKAlgAESGCM,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// PutObject encrypts plaintext and stores it under bucket/key. encryptionContext
+// is bound to the wrapped DEK in addition to the fixed aws:x-amz-cek-alg
+// entry this package always adds, and is stored in the object's
+// x-amz-matdesc metadata, from which DecryptionClient.GetObject reads it
+// back to unwrap the DEK.
+func (c *EncryptionClient) PutObject(ctx context.Context, bucket, key string, plaintext []byte, encryptionContext map[string]string) error {
+	boundContext := make(map[string]string, len(encryptionContext)+1)
+	for k, v := range encryptionContext {
+		boundContext[k] = v
+	}
+	boundContext[cekAlgContextKey] = c.cekAlg
+
+	dekResp, err := c.kms.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             &c.keyURI,
+		NumberOfBytes:     int32Ptr(dekSizeBytes),
+		EncryptionContext: boundContext,
+	})
+	if err != nil {
+		return fmt.Errorf("s3crypto: generating data key: %v", err)
+	}
+
+	ciphertext, iv, tagLenBits, err := encryptBody(c.cekAlg, dekResp.Plaintext, plaintext)
+	if err != nil {
+		return fmt.Errorf("s3crypto: encrypting object body: %v", err)
+	}
+
+	env := &envelope{
+		wrappedKey:        dekResp.CiphertextBlob,
+		iv:                iv,
+		tagLenBits:        tagLenBits,
+		cekAlg:            c.cekAlg,
+		encryptionContext: boundContext,
+	}
+	metadata, err := env.toMetadata()
+	if err != nil {
+		return err
+	}
+	return c.store.PutObject(ctx, bucket, key, ciphertext, metadata)
+}
+
+// DecryptionClient decrypts objects previously encrypted by an
+// EncryptionClient (or by another "kms+context" implementation).
+type DecryptionClient struct {
+	store ObjectStore
+	kms   KeyManager
+}
+
+// NewDecryptionClient returns a DecryptionClient that reads objects via
+// store and unwraps DEKs with kmsClient.
+func NewDecryptionClient(store ObjectStore, kmsClient KeyManager) *DecryptionClient {
+	return &DecryptionClient{store: store, kms: kmsClient}
+}
+
+// GetObject fetches bucket/key and returns the decrypted plaintext.
+//
+// Decryption fails if the object's x-amz-cek-alg metadata does not match
+// the aws:x-amz-cek-alg entry bound into the wrapped key's encryption
+// context, which would otherwise let an attacker who can edit the
+// (unauthenticated) object metadata make a ciphertext produced with one
+// content-encryption algorithm be decrypted as if it used another.
+func (c *DecryptionClient) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	ciphertext, metadata, err := c.store.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	env, err := envelopeFromMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	dekResp, err := c.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    env.wrappedKey,
+		EncryptionContext: env.encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: unwrapping data key: %v", err)
+	}
+
+	plaintext, err := decryptBody(env.cekAlg, dekResp.Plaintext, ciphertext, env.iv, env.tagLenBits)
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: decrypting object body: %v", err)
+	}
+	return plaintext, nil
+}
+
+func int32Ptr(v int32) *int32 { return &v }
@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package s3crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// User metadata keys used to store the envelope alongside the ciphertext.
+// These match the names the AWS SDK S3 encryption clients use, so objects
+// are wire-compatible across implementations.
+const (
+	metaWrapAlg = "x-amz-wrap-alg"
+	metaCEKAlg  = "x-amz-cek-alg"
+	metaKeyV2   = "x-amz-key-v2"
+	metaIV      = "x-amz-iv"
+	metaTagLen  = "x-amz-tag-len"
+	metaMatDesc = "x-amz-matdesc"
+
+	// wrapAlgKMSContext identifies the "kms+context" key wrapping scheme:
+	// the DEK is wrapped with KMS and the encryption context is bound to
+	// the wrap via an additional fixed entry keyed by cekAlgContextKey.
+	wrapAlgKMSContext = "kms+context"
+
+	// cekAlgContextKey is the fixed encryption-context entry that binds the
+	// content-encryption algorithm to the wrapped key, preventing a
+	// ciphertext encrypted with one CEK algorithm from being decrypted as
+	// if it were produced with another.
+	cekAlgContextKey = "aws:x-amz-cek-alg"
+)
+
+// Content encryption algorithms supported for the object body.
+const (
+	// CEKAlgAESGCM encrypts the object body with AES-GCM using the DEK
+	// directly. This is the default.
+	CEKAlgAESGCM = "AES/GCM/NoPadding"
+	// CEKAlgAESCTRHMAC encrypts the object body with AES-CTR and
+	// authenticates it with a separate HMAC-SHA256 tag, using encryption and
+	// MAC keys derived from the DEK via HKDF-SHA256.
+	CEKAlgAESCTRHMAC = "AES/CTR/HMAC-SHA256"
+)
+
+// envelope holds the per-object cryptographic parameters stored in user
+// metadata next to the ciphertext.
+type envelope struct {
+	wrappedKey        []byte
+	iv                []byte
+	tagLenBits        int
+	cekAlg            string
+	encryptionContext map[string]string
+}
+
+// toMetadata renders the envelope as the user-metadata entries that get
+// stored alongside the ciphertext.
+func (e *envelope) toMetadata() (map[string]string, error) {
+	matdesc, err := json.Marshal(e.encryptionContext)
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: marshaling encryption context: %v", err)
+	}
+	return map[string]string{
+		metaWrapAlg: wrapAlgKMSContext,
+		metaCEKAlg:  e.cekAlg,
+		metaKeyV2:   base64.StdEncoding.EncodeToString(e.wrappedKey),
+		metaIV:      base64.StdEncoding.EncodeToString(e.iv),
+		metaTagLen:  strconv.Itoa(e.tagLenBits),
+		metaMatDesc: string(matdesc),
+	}, nil
+}
+
+// envelopeFromMetadata parses the envelope previously written by
+// toMetadata out of an object's user metadata.
+func envelopeFromMetadata(metadata map[string]string) (*envelope, error) {
+	wrapAlg, ok := metadata[metaWrapAlg]
+	if !ok {
+		return nil, fmt.Errorf("s3crypto: object is missing %q metadata", metaWrapAlg)
+	}
+	if wrapAlg != wrapAlgKMSContext {
+		return nil, fmt.Errorf("s3crypto: unsupported wrap algorithm %q, want %q", wrapAlg, wrapAlgKMSContext)
+	}
+	cekAlg, ok := metadata[metaCEKAlg]
+	if !ok {
+		return nil, fmt.Errorf("s3crypto: object is missing %q metadata", metaCEKAlg)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(metadata[metaKeyV2])
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: decoding %q metadata: %v", metaKeyV2, err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(metadata[metaIV])
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: decoding %q metadata: %v", metaIV, err)
+	}
+	tagLenBits, err := strconv.Atoi(metadata[metaTagLen])
+	if err != nil {
+		return nil, fmt.Errorf("s3crypto: parsing %q metadata: %v", metaTagLen, err)
+	}
+	var encryptionContext map[string]string
+	if err := json.Unmarshal([]byte(metadata[metaMatDesc]), &encryptionContext); err != nil {
+		return nil, fmt.Errorf("s3crypto: parsing %q metadata: %v", metaMatDesc, err)
+	}
+	// The CEK algorithm bound into the wrapped key's encryption context
+	// must match the one recorded for the ciphertext; otherwise an
+	// attacker who can edit unauthenticated object metadata could swap
+	// x-amz-cek-alg to make a GCM ciphertext be interpreted as CTR (or
+	// vice versa) without KMS noticing, since the two live in different
+	// fields. Checking here makes the substitution fail closed.
+	if bound := encryptionContext[cekAlgContextKey]; bound != cekAlg {
+		return nil, fmt.Errorf("s3crypto: %q metadata (%q) does not match the cek-alg bound into the encryption context (%q)", metaCEKAlg, cekAlg, bound)
+	}
+	return &envelope{
+		wrappedKey:        wrappedKey,
+		iv:                iv,
+		tagLenBits:        tagLenBits,
+		cekAlg:            cekAlg,
+		encryptionContext: encryptionContext,
+	}, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+// Package s3crypto provides client-side envelope encryption for S3 objects
+// using AWS KMS key wrapping, compatible with the "kms+context" wrapping
+// algorithm used by the AWS SDK S3 encryption clients.
+//
+// For every object, EncryptionClient generates a fresh data encryption key
+// (DEK), wraps it with a KMS customer master key via GenerateDataKey while
+// binding an encryption context to the wrap, encrypts the object body with
+// the DEK, and stores the wrapped key and the cryptographic parameters
+// needed to reverse the process in the object's user metadata. DecryptionClient
+// reads that metadata back, unwraps the DEK with KMS, and decrypts the body.
+//
+// Ciphertexts produced by this package use the same user-metadata layout as
+// the "kms+context" scheme of the AWS SDK for Go V2 S3 encryption client
+// (https://github.com/aws/amazon-s3-encryption-client-go), so AES-GCM
+// objects written by one are readable by the other. Objects written with
+// CEKAlgAESCTRHMAC derive their keys with an HKDF step specific to this
+// package and are readable only by this package.
+package s3crypto
+
+import "context"
+
+// ObjectStore is the subset of an S3 client that EncryptionClient and
+// DecryptionClient need in order to read and write objects. It lets callers
+// bring their own S3 client (the official SDK, a test double, a wrapper
+// that adds retries, etc.) instead of this package depending on a
+// particular SDK version.
+type ObjectStore interface {
+	// PutObject stores body under bucket/key along with the given user
+	// metadata (keys are the metadata name without any "x-amz-meta-"
+	// prefix; the store is responsible for adding it).
+	PutObject(ctx context.Context, bucket, key string, body []byte, metadata map[string]string) error
+
+	// GetObject returns the body and user metadata previously stored under
+	// bucket/key.
+	GetObject(ctx context.Context, bucket, key string) (body []byte, metadata map[string]string, err error)
+}
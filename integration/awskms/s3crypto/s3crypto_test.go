@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+////////////////////////////////////////////////////////////////////////////////
+
+package s3crypto_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+
+	"github.com/tink-crypto/tink-go-awskms/v2/integration/awskms/s3crypto"
+)
+
+// fakeKMS is a minimal in-memory stand-in for the KMS operations
+// s3crypto.KeyManager needs. It "wraps" a DEK by storing it, keyed by a
+// counter-derived ciphertext blob, alongside the encryption context it was
+// generated under, and refuses to unwrap unless the caller presents the
+// same encryption context back.
+type fakeKMS struct {
+	wrapped map[string]wrappedDEK
+	next    int
+}
+
+type wrappedDEK struct {
+	plaintext         []byte
+	encryptionContext map[string]string
+}
+
+func newFakeKMS() *fakeKMS {
+	return &fakeKMS{wrapped: make(map[string]wrappedDEK)}
+}
+
+func (f *fakeKMS) GenerateDataKey(_ context.Context, params *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	dek := make([]byte, *params.NumberOfBytes)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	f.next++
+	blob := []byte(fmt.Sprintf("wrapped-%d", f.next))
+	f.wrapped[string(blob)] = wrappedDEK{plaintext: dek, encryptionContext: params.EncryptionContext}
+	return &kms.GenerateDataKeyOutput{
+		KeyId:          params.KeyId,
+		Plaintext:      dek,
+		CiphertextBlob: blob,
+	}, nil
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, params *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	w, ok := f.wrapped[string(params.CiphertextBlob)]
+	if !ok {
+		return nil, &types.NotFoundException{Message: new(string)}
+	}
+	if len(w.encryptionContext) != len(params.EncryptionContext) {
+		return nil, fmt.Errorf("fakeKMS: encryption context mismatch")
+	}
+	for k, v := range w.encryptionContext {
+		if params.EncryptionContext[k] != v {
+			return nil, fmt.Errorf("fakeKMS: encryption context mismatch on %q", k)
+		}
+	}
+	return &kms.DecryptOutput{Plaintext: w.plaintext}, nil
+}
+
+// fakeStore is an in-memory ObjectStore.
+type fakeStore struct {
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	body     []byte
+	metadata map[string]string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: make(map[string]fakeObject)}
+}
+
+func (s *fakeStore) PutObject(_ context.Context, bucket, key string, body []byte, metadata map[string]string) error {
+	md := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		md[k] = v
+	}
+	s.objects[bucket+"/"+key] = fakeObject{body: append([]byte(nil), body...), metadata: md}
+	return nil
+}
+
+func (s *fakeStore) GetObject(_ context.Context, bucket, key string) ([]byte, map[string]string, error) {
+	obj, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, nil, fmt.Errorf("fakeStore: no such object %q/%q", bucket, key)
+	}
+	return obj.body, obj.metadata, nil
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts []s3crypto.EncryptionOption
+	}{
+		{name: "AES-GCM (default)"},
+		{name: "AES-CTR+HMAC", opts: []s3crypto.EncryptionOption{s3crypto.WithAESCTRHMAC()}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			store := newFakeStore()
+			kmsClient := newFakeKMS()
+			enc := s3crypto.NewEncryptionClient(store, kmsClient, "test-key-id", test.opts...)
+			dec := s3crypto.NewDecryptionClient(store, kmsClient)
+
+			plaintext := []byte("object body to protect")
+			encContext := map[string]string{"purpose": "unit-test"}
+			if err := enc.PutObject(context.Background(), "bucket", "object", plaintext, encContext); err != nil {
+				t.Fatalf("PutObject() err = %v, want nil", err)
+			}
+
+			got, err := dec.GetObject(context.Background(), "bucket", "object")
+			if err != nil {
+				t.Fatalf("GetObject() err = %v, want nil", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("GetObject() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestGetObjectRejectsCEKAlgorithmSubstitution(t *testing.T) {
+	store := newFakeStore()
+	kmsClient := newFakeKMS()
+	enc := s3crypto.NewEncryptionClient(store, kmsClient, "test-key-id")
+	dec := s3crypto.NewDecryptionClient(store, kmsClient)
+
+	if err := enc.PutObject(context.Background(), "bucket", "object", []byte("secret"), nil); err != nil {
+		t.Fatalf("PutObject() err = %v, want nil", err)
+	}
+
+	// Tamper with the unauthenticated x-amz-cek-alg metadata to claim the
+	// ciphertext uses a different content-encryption algorithm than the one
+	// bound into the wrapped key's encryption context.
+	obj := store.objects["bucket/object"]
+	obj.metadata["x-amz-cek-alg"] = s3crypto.CEKAlgAESCTRHMAC
+	store.objects["bucket/object"] = obj
+
+	if _, err := dec.GetObject(context.Background(), "bucket", "object"); err == nil {
+		t.Error("GetObject() err = nil after cek-alg substitution, want error")
+	}
+}